@@ -0,0 +1,22 @@
+#!/bin/sh
+# Release build of pipes: the static linux pipes_client,
+# and the pipes CLI with the checksum of the pipes_client embedded.
+# Upload release/<version>/pipes_client to <PIPES_CLIENT_URL>/<version>/pipes_client
+set -e
+cd "$(dirname "$0")/.."
+
+VERSION=$(sed -n 's/^[[:space:]]*VERSION = "\(.*\)"/\1/p' main.go)
+CLIENT_VERSION=$(sed -n 's/^var VERSION = "\(.*\)"/\1/p' src/wrapper/client/main.go)
+if [ "$VERSION" != "$CLIENT_VERSION" ]; then
+	echo "Version of pipes ($VERSION) and pipes_client ($CLIENT_VERSION) differ" >&2
+	exit 1
+fi
+OUT=release/$VERSION
+mkdir -p $OUT
+
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o $OUT/pipes_client ./src/wrapper/client
+SUM=$(sha256sum $OUT/pipes_client | cut -d ' ' -f 1)
+echo "$SUM  pipes_client" > $OUT/pipes_client.sha256
+
+go build -ldflags "-X github.com/francisbouvier/pipes/src/builder.PipesClientChecksum=$SUM" -o $OUT/pipes .
+echo "pipes $VERSION, pipes_client $SUM"
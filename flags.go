@@ -11,6 +11,12 @@ var logLevelFlag = cli.StringFlag{
 	Usage: "Log verbose output (debug, info, warn).",
 }
 
+var logFormatFlag = cli.StringFlag{
+	Name:  "log-format",
+	Value: "text",
+	Usage: "Log format (text, json).",
+}
+
 var nameFlag = cli.StringFlag{
 	Name:  "name",
 	Value: "default",
@@ -33,7 +39,198 @@ var daemonFlag = cli.BoolFlag{
 	Usage: "Run in daemon mode.",
 }
 
+var fileFlag = cli.StringSliceFlag{
+	Name:  "file",
+	Value: &cli.StringSlice{},
+	Usage: "File (or directory of files) submitted as a job, can be repeated.",
+}
+
+var stdinFlag = cli.BoolFlag{
+	Name:  "stdin",
+	Usage: "Submit the stdin (a pipe or a file) as a job.",
+}
+
+var perLineFlag = cli.BoolFlag{
+	Name:  "per-line",
+	Usage: "Submit each line of the files or of the stdin as a separate job.",
+}
+
+var queryParallelFlag = cli.IntFlag{
+	Name:  "parallel, P",
+	Value: 4,
+	Usage: "Maximum jobs in flight.",
+}
+
+var outputFlag = cli.StringFlag{
+	Name:  "output, o",
+	Usage: "Directory where the result of each job is written. Default is stdout.",
+}
+
+var dryRunFlag = cli.BoolFlag{
+	Name:  "dry-run",
+	Usage: "Print the containers to be created, without running them.",
+}
+
 var allFlag = cli.BoolFlag{
 	Name:  "a",
 	Usage: "Display all.",
 }
+
+var tlsFlag = cli.BoolFlag{
+	Name:  "tls",
+	Usage: "Serve the API over HTTPS with a self-signed certificate.",
+}
+
+var tlsCertFlag = cli.StringFlag{
+	Name:  "tls-cert",
+	Usage: "Certificate file for the API (implies --tls).",
+}
+
+var tlsKeyFlag = cli.StringFlag{
+	Name:  "tls-key",
+	Usage: "Key file of the API certificate.",
+}
+
+var tlsCAFlag = cli.StringFlag{
+	Name:  "tls-ca",
+	Usage: "CA file used to verify the API. Default is the certificate itself.",
+}
+
+var rateFlag = cli.IntFlag{
+	Name:  "rate",
+	Usage: "Maximum requests per second on the API. Default is unlimited.",
+}
+
+var maxJobsFlag = cli.IntFlag{
+	Name:  "max-jobs",
+	Usage: "Maximum jobs in flight on the API. Default is unlimited.",
+}
+
+var concurrencyFlag = cli.StringSliceFlag{
+	Name:  "concurrency",
+	Value: &cli.StringSlice{},
+	Usage: "Maximum concurrent executions of a service: <service>=<n>.",
+}
+
+var traceFormatFlag = cli.StringFlag{
+	Name:  "format",
+	Usage: "Output format (otlp). Default is a timeline.",
+}
+
+var followFlag = cli.BoolFlag{
+	Name:  "f",
+	Usage: "Follow log output.",
+}
+
+var inspectFormatFlag = cli.StringFlag{
+	Name:  "format",
+	Value: "json",
+	Usage: "Output format (json, table).",
+}
+
+var formatFlag = cli.StringFlag{
+	Name:  "format",
+	Usage: "Output format (table, json, yaml) or a Go template, ie. '{{.ID}} {{.Name}}'.",
+}
+
+var filterFlag = cli.StringSliceFlag{
+	Name:  "filter",
+	Value: &cli.StringSlice{},
+	Usage: "Filter output: name=<name>, status=<running|exited>, service=<service>.",
+}
+
+var updateTimeoutFlag = cli.IntFlag{
+	Name:  "timeout",
+	Value: 30,
+	Usage: "Seconds to wait for the new containers to be ready.",
+}
+
+var queryTimeoutFlag = cli.IntFlag{
+	Name:  "timeout",
+	Value: 10,
+	Usage: "Seconds to wait for the result of each query.",
+}
+
+var stopTimeoutFlag = cli.IntFlag{
+	Name:  "stop-timeout",
+	Value: 10,
+	Usage: "Seconds given to the old containers to drain their jobs before being killed.",
+}
+
+var tagFlag = cli.StringFlag{
+	Name:  "tag, t",
+	Usage: "Tag of the built images. Default is the hash of the build.",
+}
+
+var templateFlag = cli.StringFlag{
+	Name:  "template",
+	Usage: "Go template of the Dockerfile of the executables, the pipes_client is added as ENTRYPOINT.",
+}
+
+var pipesClientFlag = cli.StringFlag{
+	Name:  "pipes-client",
+	Usage: "Path of the pipes_client added to the images. Default is the one matching the version of pipes.",
+}
+
+var offlineFlag = cli.BoolFlag{
+	Name:  "offline",
+	Usage: "Do not download the pipes_client.",
+}
+
+var insecureClientFlag = cli.BoolFlag{
+	Name:  "insecure-client",
+	Usage: "Accept a pipes_client not matching the checksum of the release, or built from the sources.",
+}
+
+var nodeFlag = cli.StringSliceFlag{
+	Name:  "node",
+	Value: &cli.StringSlice{},
+	Usage: "IP of a node where the images are built or copied. Default is any node.",
+}
+
+var allNodesFlag = cli.BoolFlag{
+	Name:  "all-nodes",
+	Usage: "Build the images on all the nodes of the cluster.",
+}
+
+var forceFlag = cli.BoolFlag{
+	Name:  "force",
+	Usage: "Build the services even if they did not change since their last build.",
+}
+
+var parallelFlag = cli.IntFlag{
+	Name:  "parallel",
+	Value: 4,
+	Usage: "Number of services built in parallel.",
+}
+
+var baseImageFlag = cli.StringSliceFlag{
+	Name:  "base-image",
+	Value: &cli.StringSlice{},
+	Usage: "Base image of a service or a runtime: <service|runtime>=<image>, eg. python=python:2.7.",
+}
+
+var registryFlag = cli.BoolFlag{
+	Name:  "registry",
+	Usage: "Run a local registry on the cluster, where built images are pushed.",
+}
+
+var registryAddrFlag = cli.StringFlag{
+	Name:  "registry-addr",
+	Usage: "Address of an external registry (host:port), where built images are pushed.",
+}
+
+var registryUserFlag = cli.StringFlag{
+	Name:  "registry-user",
+	Usage: "Username of the registry.",
+}
+
+var registryPasswordFlag = cli.StringFlag{
+	Name:  "registry-password",
+	Usage: "Password of the registry.",
+}
+
+var registryEmailFlag = cli.StringFlag{
+	Name:  "registry-email",
+	Usage: "Email of the registry account.",
+}
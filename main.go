@@ -12,6 +12,7 @@ import (
 	"github.com/francisbouvier/pipes/src/controller"
 	"github.com/francisbouvier/pipes/src/discovery"
 	_ "github.com/francisbouvier/pipes/src/store/etcd"
+	"github.com/francisbouvier/pipes/src/utils"
 )
 
 var (
@@ -30,7 +31,7 @@ func main() {
 	app.Author = strings.Join(append([]string{Author}, Contributors...), "\n   ")
 	app.Version = VERSION
 	app.Usage = "A micro-services framework"
-	app.Flags = []cli.Flag{logLevelFlag}
+	app.Flags = []cli.Flag{logLevelFlag, logFormatFlag}
 
 	app.Before = func(c *cli.Context) error {
 		switch c.String("log") {
@@ -41,14 +42,18 @@ func main() {
 		default:
 			log.SetLevel(log.WarnLevel)
 		}
-		return nil
+		return utils.SetLogFormat(c.String("log-format"))
 	}
 
 	app.Commands = []cli.Command{
 		{
 			Name:  "init",
 			Usage: "Initiate a cluster",
-			Flags: []cli.Flag{nameFlag, serversFlag},
+			Flags: []cli.Flag{
+				nameFlag, serversFlag,
+				registryFlag, registryAddrFlag, registryUserFlag,
+				registryPasswordFlag, registryEmailFlag,
+			},
 			Action: func(c *cli.Context) {
 
 				err := discovery.Initialize(c)
@@ -60,7 +65,11 @@ func main() {
 		{
 			Name:  "build",
 			Usage: "Build a micro-service",
-			Flags: []cli.Flag{nameFlag, serversFlag},
+			Flags: []cli.Flag{
+				nameFlag, serversFlag, tagFlag, baseImageFlag, templateFlag,
+				pipesClientFlag, offlineFlag, insecureClientFlag, parallelFlag, forceFlag,
+				nodeFlag, allNodesFlag,
+			},
 			Action: func(c *cli.Context) {
 				if err := builder.BuildDockerImagesFromExec(c.Args(), c); err != nil {
 					log.Fatalln(err)
@@ -70,7 +79,12 @@ func main() {
 		{
 			Name:  "run",
 			Usage: "Run a workfow",
-			Flags: []cli.Flag{daemonFlag, controllerNameFlag},
+			Flags: []cli.Flag{
+				daemonFlag, controllerNameFlag,
+				tlsFlag, tlsCertFlag, tlsKeyFlag, tlsCAFlag,
+				rateFlag, maxJobsFlag, concurrencyFlag, dryRunFlag,
+				queryTimeoutFlag,
+			},
 			Action: func(c *cli.Context) {
 				if err := controller.Run(c); err != nil {
 					log.Fatalln(err)
@@ -80,13 +94,92 @@ func main() {
 		{
 			Name:  "query",
 			Usage: "Query a workfow",
-			Flags: []cli.Flag{daemonFlag, controllerNameFlag},
+			Flags: []cli.Flag{
+				daemonFlag, controllerNameFlag,
+				fileFlag, stdinFlag, perLineFlag, queryParallelFlag, outputFlag,
+				queryTimeoutFlag,
+			},
 			Action: func(c *cli.Context) {
 				if err := controller.Query(c); err != nil {
 					log.Fatalln(err)
 				}
 			},
 		},
+		{
+			Name:  "apikey",
+			Usage: "Manage API keys of a workflow",
+			Subcommands: []cli.Command{
+				{
+					Name:  "create",
+					Usage: "Create an API key",
+					Flags: []cli.Flag{controllerNameFlag},
+					Action: func(c *cli.Context) {
+						if err := controller.APIKeyCreate(c); err != nil {
+							log.Fatalln(err)
+						}
+					},
+				},
+				{
+					Name:  "ls",
+					Usage: "List API keys",
+					Flags: []cli.Flag{controllerNameFlag, formatFlag},
+					Action: func(c *cli.Context) {
+						if err := controller.APIKeyList(c); err != nil {
+							log.Fatalln(err)
+						}
+					},
+				},
+				{
+					Name:  "revoke",
+					Usage: "Revoke an API key",
+					Flags: []cli.Flag{controllerNameFlag},
+					Action: func(c *cli.Context) {
+						if err := controller.APIKeyRevoke(c); err != nil {
+							log.Fatalln(err)
+						}
+					},
+				},
+			},
+		},
+		{
+			Name:  "inspect",
+			Usage: "Display detailed information on a workflow",
+			Flags: []cli.Flag{inspectFormatFlag},
+			Action: func(c *cli.Context) {
+				if err := controller.Inspect(c); err != nil {
+					log.Fatalln(err)
+				}
+			},
+		},
+		{
+			Name:  "logs",
+			Usage: "Logs of a workflow: logs <project> [service...]",
+			Flags: []cli.Flag{followFlag},
+			Action: func(c *cli.Context) {
+				if err := controller.Logs(c); err != nil {
+					log.Fatalln(err)
+				}
+			},
+		},
+		{
+			Name:  "metrics",
+			Usage: "Metrics of a workflow",
+			Action: func(c *cli.Context) {
+				if err := controller.Metrics(c); err != nil {
+					log.Fatalln(err)
+				}
+			},
+		},
+		{
+			Name:  "trace",
+			Usage: "Trace a job through the workflow",
+			Flags: []cli.Flag{controllerNameFlag, traceFormatFlag},
+			Action: func(c *cli.Context) {
+				if err := controller.Trace(c); err != nil {
+					log.Fatalln(err)
+				}
+			},
+		},
 		{
 			Name:  "rm",
 			Usage: "Remove workfow",
@@ -96,10 +189,38 @@ func main() {
 				}
 			},
 		},
+		{
+			Name:  "restart",
+			Usage: "Restart a workflow: restart <project>",
+			Action: func(c *cli.Context) {
+				if err := controller.Restart(c); err != nil {
+					log.Fatalln(err)
+				}
+			},
+		},
+		{
+			Name:  "redeploy",
+			Usage: "Redeploy services of a workflow: redeploy <project> [service...]",
+			Action: func(c *cli.Context) {
+				if err := controller.Redeploy(c); err != nil {
+					log.Fatalln(err)
+				}
+			},
+		},
+		{
+			Name:  "update",
+			Usage: "Rolling update of services: update <project> <service...>",
+			Flags: []cli.Flag{updateTimeoutFlag, stopTimeoutFlag},
+			Action: func(c *cli.Context) {
+				if err := controller.Update(c); err != nil {
+					log.Fatalln(err)
+				}
+			},
+		},
 		{
 			Name:  "ps",
 			Usage: "List workflows",
-			Flags: []cli.Flag{allFlag},
+			Flags: []cli.Flag{allFlag, formatFlag, filterFlag},
 			Action: func(c *cli.Context) {
 				if err := controller.List(c); err != nil {
 					log.Fatalln(err)
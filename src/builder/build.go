@@ -0,0 +1,402 @@
+package builder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/francisbouvier/pipes/src/engine"
+	"github.com/francisbouvier/pipes/src/orch/swarm"
+	"github.com/francisbouvier/pipes/src/registry"
+	"github.com/francisbouvier/pipes/src/store"
+)
+
+// Builds run in parallel by default
+const CONCURRENCY = 4
+
+type Options struct {
+	Tag         string            // Default is the hash of the build context
+	BaseImages  map[string]string // By service or runtime
+	Template    string            // Go template of the Dockerfile of the executables
+	Client      string            // pipes_client added to the images
+	Version     string            // Version of the pipes_client
+	Nodes       []string          // Nodes holding the images, default is any node
+	AllNodes    bool
+	Concurrency int
+	Force       bool // Build even if the inputs did not change
+}
+
+// Service built from an executable or a directory
+type Service struct {
+	Path      string
+	InputMode string
+}
+
+// Service given as <path>[:<input_mode>]
+func ParseService(arg string) Service {
+	parts := strings.SplitN(arg, ":", 2)
+	s := Service{Path: parts[0], InputMode: "stdin"}
+	if len(parts) > 1 {
+		s.InputMode = parts[1]
+	}
+	return s
+}
+
+type Result struct {
+	Service  string
+	Image    string
+	Tag      string
+	Log      string
+	Duration time.Duration
+	Cached   bool // Inputs did not change since the last build
+	Err      error
+}
+
+type Builder struct {
+	Store   store.Store
+	Swarm   swarm.Swarm
+	Options Options
+	// If set, the build logs are also written on Output,
+	// each line prefixed by its service
+	Output io.Writer
+	mu     sync.Mutex
+}
+
+func New(st store.Store, opts Options) (*Builder, error) {
+	if opts.Tag == "latest" || strings.Contains(opts.Tag, "@") {
+		return nil, errors.New(fmt.Sprintf("Invalid tag: %s", opts.Tag))
+	}
+	if opts.Client == "" {
+		return nil, errors.New("No pipes_client")
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = CONCURRENCY
+	}
+	sw, err := swarm.New(st)
+	if err != nil {
+		return nil, err
+	}
+	if opts.AllNodes {
+		if opts.Nodes, err = sw.Nodes(); err != nil {
+			return nil, err
+		}
+		if len(opts.Nodes) == 0 {
+			return nil, errors.New("No node in the cluster")
+		}
+	}
+	for i, node := range opts.Nodes {
+		opts.Nodes[i] = swarm.NodeAddr(node)
+	}
+	return &Builder{Store: st, Swarm: sw, Options: opts}, nil
+}
+
+// Build the services concurrently,
+// the results are in the order of the services
+func (b *Builder) Build(services []Service) []Result {
+	results := make([]Result, len(services))
+	sem := make(chan struct{}, b.Options.Concurrency)
+	var wg sync.WaitGroup
+	for i, s := range services {
+		wg.Add(1)
+		go func(i int, s Service) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = b.BuildService(s)
+		}(i, s)
+	}
+	wg.Wait()
+	return results
+}
+
+// Build the image of a service and record it in the store
+func (b *Builder) BuildService(s Service) (res Result) {
+	res.Service = serviceName(s.Path)
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	if b.Output != nil {
+		pw := &prefixWriter{prefix: res.Service, w: b.Output, mu: &b.mu}
+		defer pw.flush()
+		w = io.MultiWriter(&buf, pw)
+	}
+	start := time.Now()
+	res.Image, res.Tag, res.Cached, res.Err = b.build(s, w)
+	res.Duration = time.Since(start)
+	if res.Err != nil {
+		fmt.Fprintln(w, "Error:", res.Err)
+	}
+	res.Log = buf.String()
+	return
+}
+
+func (b *Builder) build(s Service, w io.Writer) (imageName, tag string, cached bool, err error) {
+	category, err := b.category(s.Path)
+	if err != nil {
+		return
+	}
+	if category.execType == dockerfileCategory.execType {
+		fmt.Fprintf(w, "Directory %s will be dockerized from its Dockerfile\n", s.Path)
+	} else {
+		fmt.Fprintf(w, "File %s is a %s file, and will be dockerized from the base image '%s'\n", s.Path, category.execType, category.baseDockerImage)
+	}
+	command, err := serviceCommand(s.Path, category)
+	if err != nil {
+		return
+	}
+
+	tmp_dir_path, imageName, err := b.context(s.Path, category, command)
+	if tmp_dir_path != "" {
+		defer os.RemoveAll(tmp_dir_path)
+	}
+	if err != nil {
+		return
+	}
+	service_name := serviceName(s.Path)
+	hash, err := b.inputsHash(tmp_dir_path, category)
+	if err != nil {
+		return
+	}
+	if tag, cached = b.cached(service_name, imageName, hash); cached {
+		fmt.Fprintf(w, "Image '%s:%s' is up to date, use --force to rebuild it\n", imageName, tag)
+	} else if tag, err = b.dockerBuild(tmp_dir_path, imageName, w); err != nil {
+		return
+	}
+
+	if err = WriteModeInStore(b.Store, service_name, s.InputMode); err != nil {
+		return
+	}
+	if err = WriteCommandInStore(b.Store, service_name, command); err != nil {
+		return
+	}
+	if cached {
+		return
+	}
+	if err = WriteBuildInStore(b.Store, service_name, tag); err != nil {
+		return
+	}
+	err = WriteHashInStore(b.Store, service_name, hash)
+	return
+}
+
+// Hash of the inputs of a build: the build context (executable, manifests,
+// pipes_client and Dockerfile), the base image and the pipes_client version
+func (b *Builder) inputsHash(tmp_dir_path string, category categorization) (string, error) {
+	hash, err := HashDirectory(tmp_dir_path)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256([]byte(strings.Join([]string{hash, category.baseDockerImage, b.Options.Version}, "\x00")))
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Tag of the last build of the service if it had the same inputs
+// and its image is still there
+func (b *Builder) cached(service_name, imageName, hash string) (tag string, ok bool) {
+	if b.Options.Force {
+		return
+	}
+	dir := fmt.Sprintf("services/%s", service_name)
+	if last, err := b.Store.Read("hash", dir); err != nil || last != hash {
+		return
+	}
+	tag, err := b.Store.Read("latest", dir)
+	if err != nil || (b.Options.Tag != "" && b.Options.Tag != tag) {
+		return "", false
+	}
+	fullName := fmt.Sprintf("%s:%s", imageName, tag)
+	if len(b.Options.Nodes) == 0 {
+		if _, err = b.Swarm.GetImg(fullName); err != nil {
+			return "", false
+		}
+		return tag, true
+	}
+	for _, node := range b.Options.Nodes {
+		eng, err := b.Swarm.Node(node)
+		if err != nil {
+			return "", false
+		}
+		if _, err = eng.GetImg(fullName); err != nil {
+			return "", false
+		}
+	}
+	return tag, true
+}
+
+// Build context of the service in a temp dir, with its Dockerfile
+func (b *Builder) context(p string, category categorization, command string) (tmp_dir_path, imageName string, err error) {
+	client := b.Options.Client
+	switch {
+	case category.execType == dockerfileCategory.execType:
+		if tmp_dir_path, err = SetTempDirectoryFromUserDir(p, client); err != nil {
+			return
+		}
+		imageName, err = CreateUserDockerfile(tmp_dir_path, p, command, category)
+	case isGoPackage(p):
+		var pkg string
+		if tmp_dir_path, pkg, err = SetTempDirectoryFromGo(p, client); err != nil {
+			return
+		}
+		imageName, err = CreateGoDockerfile(tmp_dir_path, p, pkg, category)
+	case isDirectory(p):
+		if tmp_dir_path, err = SetTempDirectoryFromDir(p, client); err != nil {
+			return
+		}
+		imageName, err = CreateDirectoryDockerfile(tmp_dir_path, p, category)
+	default:
+		var new_exec_path, exec_file_name string
+		if tmp_dir_path, new_exec_path, exec_file_name, err = SetTempDirectory(p, client); err != nil {
+			return
+		}
+		if b.Options.Template != "" {
+			imageName, err = CreateTemplateDockerfile(tmp_dir_path, b.Options.Template, exec_file_name, command, category)
+		} else {
+			imageName, err = CreateDockerfile(tmp_dir_path, new_exec_path, exec_file_name, category)
+		}
+	}
+	return
+}
+
+// Launch a docker build from the Dockerfile of the temp dir,
+// through the swarm manager or on the first of the given nodes.
+// The image is tagged with the tag option or the hash of the build context,
+// and as latest, then copied on the other nodes
+// and pushed to the registry of the cluster if any.
+func (b *Builder) dockerBuild(tmp_dir_path, imageName string, w io.Writer) (tag string, err error) {
+	tag = b.Options.Tag
+	if tag == "" {
+		if tag, err = HashDirectory(tmp_dir_path); err != nil {
+			return
+		}
+	}
+	fullName := fmt.Sprintf("%s:%s", imageName, tag)
+
+	var eng engine.Engine = b.Swarm
+	if len(b.Options.Nodes) > 0 {
+		if eng, err = b.Swarm.Node(b.Options.Nodes[0]); err != nil {
+			return
+		}
+		fmt.Fprintf(w, "Building on node %s\n", b.Options.Nodes[0])
+	}
+	fmt.Fprintf(w, "Building Docker image named '%s' from Dockerfile located at %s/Dockerfile\n", fullName, tmp_dir_path)
+	if _, err = eng.BuildImg(fullName, tmp_dir_path, w); err != nil {
+		return
+	}
+	if err = eng.TagImg(fullName, imageName+":latest"); err != nil {
+		return
+	}
+	if err = b.distribute(eng, imageName, tag, w); err != nil {
+		return
+	}
+
+	// Push to the registry of the cluster, if any
+	reg, regErr := registry.Get(b.Store)
+	if regErr != nil {
+		return
+	}
+	for _, t := range []string{tag, "latest"} {
+		remote := fmt.Sprintf("%s:%s", reg.Image(imageName), t)
+		if err = eng.TagImg(fullName, remote); err != nil {
+			return
+		}
+		if err = eng.PushImg(remote, reg.Auth, w); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Copy the image from the engine which built it on the other given nodes,
+// and record the nodes holding it
+func (b *Builder) distribute(src engine.Engine, imageName, tag string, w io.Writer) error {
+	fullName := fmt.Sprintf("%s:%s", imageName, tag)
+	names := []string{fullName, imageName + ":latest"}
+	if len(b.Options.Nodes) == 0 {
+		for _, name := range names {
+			if err := b.Swarm.ClearImageNodes(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, node := range b.Options.Nodes {
+		if i > 0 {
+			fmt.Fprintf(w, "Copying image '%s' on node %s\n", fullName, node)
+			dst, err := b.Swarm.Node(node)
+			if err != nil {
+				return err
+			}
+			if err = engine.CopyImg(fullName, src, dst); err != nil {
+				return err
+			}
+			if err = dst.TagImg(fullName, imageName+":latest"); err != nil {
+				return err
+			}
+		}
+		for _, name := range names {
+			if err := b.Swarm.SetImageNode(name, node); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func WriteSummary(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tIMAGE\tDURATION\tSTATUS")
+	for _, res := range results {
+		image, status := "-", "Built"
+		if res.Image != "" && res.Tag != "" {
+			image = fmt.Sprintf("%s:%s", res.Image, res.Tag)
+		}
+		if res.Err != nil {
+			status = fmt.Sprintf("Failed: %s", res.Err)
+		} else if res.Cached {
+			status = "Up to date"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", res.Service, image, res.Duration.Round(time.Millisecond), status)
+	}
+	return tw.Flush()
+}
+
+// Write the lines of a build log, prefixed by the service
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (pw *prefixWriter) Write(b []byte) (int, error) {
+	pw.buf = append(pw.buf, b...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i == -1 {
+			break
+		}
+		pw.writeLine(pw.buf[:i])
+		pw.buf = pw.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (pw *prefixWriter) flush() {
+	if len(pw.buf) > 0 {
+		pw.writeLine(pw.buf)
+		pw.buf = nil
+	}
+}
+
+func (pw *prefixWriter) writeLine(line []byte) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	fmt.Fprintf(pw.w, "%s | %s\n", pw.prefix, line)
+}
@@ -0,0 +1,19 @@
+package builder
+
+import "testing"
+
+func TestParseService(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want Service
+	}{
+		{"service.py", Service{Path: "service.py", InputMode: "stdin"}},
+		{"service.py:args", Service{Path: "service.py", InputMode: "args"}},
+		{"dir/service:stdin", Service{Path: "dir/service", InputMode: "stdin"}},
+	}
+	for _, tt := range tests {
+		if got := ParseService(tt.arg); got != tt.want {
+			t.Errorf("ParseService(%q) = %+v, want %+v", tt.arg, got, tt.want)
+		}
+	}
+}
@@ -1,22 +1,24 @@
 package builder
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"time"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 
 	"github.com/francisbouvier/pipes/src/discovery"
-	"github.com/francisbouvier/pipes/src/orch/swarm"
-	"github.com/francisbouvier/pipes/src/utils"
+	"github.com/francisbouvier/pipes/src/store"
 )
 
-const PIPES_CLIENT = "https://s3-us-west-1.amazonaws.com/pipesdocker/pipes_client"
-
 type categorization struct {
 	execType        string
 	baseDockerImage string
@@ -34,6 +36,46 @@ var (
 		baseDockerImage: "ruby",
 		command:         "ruby ",
 	}
+	nodeCategory = categorization{
+		execType:        "node",
+		baseDockerImage: "node",
+		command:         "node ",
+	}
+	shellCategory = categorization{
+		execType:        "shell",
+		baseDockerImage: "bash",
+		command:         "bash ",
+	}
+	javaCategory = categorization{
+		execType:        "java",
+		baseDockerImage: "java",
+		command:         "java -jar ",
+	}
+	phpCategory = categorization{
+		execType:        "php",
+		baseDockerImage: "php",
+		command:         "php ",
+	}
+	perlCategory = categorization{
+		execType:        "perl",
+		baseDockerImage: "perl",
+		command:         "perl ",
+	}
+	rCategory = categorization{
+		execType:        "r",
+		baseDockerImage: "r-base",
+		command:         "Rscript ",
+	}
+	dockerfileCategory = categorization{
+		execType:        "dockerfile",
+		baseDockerImage: "",
+		command:         "",
+	}
+	goCategory = categorization{
+		execType:        "go",
+		baseDockerImage: "golang",
+		command:         "",
+	}
 	simpleBinaryCategory = categorization{
 		execType:        "binary",
 		baseDockerImage: "microbox/scratch",
@@ -41,141 +83,236 @@ var (
 	}
 )
 
-func check(e error) {
-	if e != nil {
-		fmt.Printf("%s\n", e)
-		panic(e)
-	}
+// Categories by file extension
+var extensions = map[string]categorization{
+	".py":  pythonCategory,
+	".rb":  rubyCategory,
+	".js":  nodeCategory,
+	".sh":  shellCategory,
+	".jar": javaCategory,
+	".php": phpCategory,
+	".pl":  perlCategory,
+	".r":   rCategory,
 }
 
-func BuildDockerImagesFromExec(args []string, c *cli.Context) (err error) { //execPath_category_map *map[string]categorization) {
-	var exec_paths []string
-
-	// Get input mode
-	for _, arg := range args {
-		arg_split_array := strings.SplitN(arg, ":", -1)
-		service_path := arg_split_array[0]
-		exec_paths = append(exec_paths, service_path)
-		service_name_array := strings.SplitN(service_path, "/", -1)
-		service_name := service_name_array[len(service_name_array)-1]
-		input_mode := "stdin"
-		if len(arg_split_array) > 1 {
-			input_mode = arg_split_array[1]
-		}
-		err = WriteModeInStore(c, service_name, input_mode)
-		if err != nil {
-			return err
-		}
-	}
+// Categories by interpreter of the shebang line
+var interpreters = map[string]categorization{
+	"python":  pythonCategory,
+	"ruby":    rubyCategory,
+	"node":    nodeCategory,
+	"nodejs":  nodeCategory,
+	"sh":      shellCategory,
+	"bash":    shellCategory,
+	"php":     phpCategory,
+	"perl":    perlCategory,
+	"Rscript": rCategory,
+}
 
-	execs_map, err := AssociateExecWithType(c, exec_paths)
+// Category from the shebang line of the file (#!/usr/bin/env python3),
+// a version in the interpreter name is used as the tag of the base image
+func shebangCategory(exec_path string) (category categorization, ok bool) {
+	f, err := os.Open(exec_path)
 	if err != nil {
-		log.Fatalln(err)
+		return
 	}
-	fmt.Println()
-
-	// Iterating through the map
-	for execOriginalPath, category := range execs_map {
-		tmp_dir_path, new_exec_path, exec_file_name := SetTempDirectory(execOriginalPath)
-		imageName := CreateDockerfile(tmp_dir_path, new_exec_path, exec_file_name, category)
-		err = DockerBuild(c, tmp_dir_path, imageName)
-		// Delete TempDir
-		defer os.RemoveAll(tmp_dir_path)
-		if err != nil {
-			log.Fatalln(err)
-		}
-		fmt.Println()
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	if !strings.HasPrefix(line, "#!") {
+		return
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+	if len(fields) == 0 {
+		return
+	}
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" && len(fields) > 1 {
+		interpreter = path.Base(fields[1])
+	}
+	name := strings.TrimRight(interpreter, "0123456789.")
+	category, ok = interpreters[name]
+	if ok && name != interpreter && category.execType != shellCategory.execType {
+		category.baseDockerImage = fmt.Sprintf("%s:%s", category.baseDockerImage, interpreter[len(name):])
 	}
-	fmt.Printf("Docker images successfully built...\n")
 	return
 }
 
-// Create a Dockerfile per executable passed through CLI
-func AssociateExecWithType(c *cli.Context, exec_paths []string) (execPath_category_map map[string]categorization, err error) {
-	// var execPath_type_map map[string]string
-	execPath_category_map = make(map[string]categorization)
-	for _, exec_path := range exec_paths {
-		switch {
-		case strings.HasSuffix(exec_path, ".py"):
-			execPath_category_map[exec_path] = pythonCategory
-			// fmt.Printf("file %s is a python file\n", exec_path)
-		case strings.HasSuffix(exec_path, ".rb"):
-			execPath_category_map[exec_path] = rubyCategory
-			// fmt.Printf("file %s is a ruby file\n", exec_path)
-		default:
-			execPath_category_map[exec_path] = simpleBinaryCategory
-			// fmt.Printf("file %s is a binary file\n", exec_path)
-		}
-		fmt.Printf("File %s is a %s file, and will be dockerized from the base image '%s'\n", exec_path, execPath_category_map[exec_path].execType, execPath_category_map[exec_path].baseDockerImage)
-		service_name_array := strings.SplitN(exec_path, "/", -1)
-		service_name := service_name_array[len(service_name_array)-1]
-		command := fmt.Sprintf(execPath_category_map[exec_path].command+"%s", "/bin/"+service_name)
-		err := WriteCommandInStore(c, service_name, command)
-		if err != nil {
-			return execPath_category_map, err
-		}
-
+// The shebang line takes precedence over the extension
+func detectCategory(exec_path string) categorization {
+	if category, ok := shebangCategory(exec_path); ok {
+		return category
+	}
+	ext := strings.ToLower(path.Ext(exec_path))
+	if category, ok := extensions[ext]; ok {
+		return category
 	}
+	return simpleBinaryCategory
+}
 
+// Base images given as <service|runtime>=<image>,
+// the service takes precedence over the runtime
+func ParseBaseImages(args []string) (images map[string]string, err error) {
+	images = map[string]string{}
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return images, errors.New(fmt.Sprintf("Invalid base image: %s", arg))
+		}
+		images[kv[0]] = kv[1]
+	}
 	return
 }
 
-func WriteCommandInStore(c *cli.Context, service_name string, command string) error {
+// Build the services given as <path>[:<input_mode>] through CLI
+func BuildDockerImagesFromExec(args []string, c *cli.Context) (err error) {
+	if len(args) == 0 {
+		return errors.New("No service to build")
+	}
 	st, err := discovery.GetStore(c)
 	if err != nil {
 		return err
 	}
-	err = st.Write("command", command, fmt.Sprintf("services/%s", service_name))
+	images, err := ParseBaseImages(c.StringSlice("base-image"))
 	if err != nil {
 		return err
 	}
-	return err
-}
-
-func WriteModeInStore(c *cli.Context, service_name string, input_mode string) error {
-	st, err := discovery.GetStore(c)
+	client, err := PipesClient(c.App.Version, c.String("pipes-client"), c.Bool("offline"), c.Bool("insecure-client"))
 	if err != nil {
 		return err
 	}
-	err = st.Write("input_mode", input_mode, fmt.Sprintf("services/%s", service_name))
+	opts := Options{
+		Tag:         c.String("tag"),
+		BaseImages:  images,
+		Template:    c.String("template"),
+		Client:      client,
+		Version:     c.App.Version,
+		Nodes:       c.StringSlice("node"),
+		AllNodes:    c.Bool("all-nodes"),
+		Concurrency: c.Int("parallel"),
+		Force:       c.Bool("force"),
+	}
+	b, err := New(st, opts)
 	if err != nil {
 		return err
 	}
-	return err
+	b.Output = os.Stdout
+
+	services := []Service{}
+	for _, arg := range args {
+		services = append(services, ParseService(arg))
+	}
+	results := b.Build(services)
+	fmt.Println()
+	if err = WriteSummary(os.Stdout, results); err != nil {
+		return err
+	}
+	failed := 0
+	for _, res := range results {
+		if res.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.New(fmt.Sprintf("%d of %d builds failed", failed, len(results)))
+	}
+	fmt.Printf("Docker images successfully built...\n")
+	return
+}
+
+// Category of the executable or directory of a service
+func (b *Builder) category(exec_path string) (category categorization, err error) {
+	if _, err = os.Stat(exec_path); err != nil {
+		return
+	}
+	category = simpleBinaryCategory
+	if _, _, ok := userDockerfile(exec_path); ok {
+		category = dockerfileCategory
+	} else if isGoPackage(exec_path) {
+		category = goCategory
+	} else if isDirectory(exec_path) {
+		if category, err = directoryCategory(exec_path); err != nil {
+			return
+		}
+	} else {
+		category = detectCategory(exec_path)
+	}
+	if image, ok := b.Options.BaseImages[category.execType]; ok {
+		category.baseDockerImage = image
+	}
+	if image, ok := b.Options.BaseImages[serviceName(exec_path)]; ok {
+		category.baseDockerImage = image
+	}
+	return
 }
 
-// Set a temp directory and cp the exec in it
-func SetTempDirectory(old_exec_path string) (tmp_dir_path, new_exec_path, exec_file_name string) {
-	// mkdir a tmp dir
+// Command run by the pipes_client of the service
+func serviceCommand(exec_path string, category categorization) (string, error) {
+	switch {
+	case category.execType == dockerfileCategory.execType:
+		return userDockerfileCommand(exec_path, category)
+	case isGoPackage(exec_path):
+		return "/bin/" + goBinary(exec_path), nil
+	case isDirectory(exec_path):
+		return directoryCommand(exec_path, category)
+	}
+	return category.command + "/bin/" + serviceName(exec_path), nil
+}
+
+func WriteCommandInStore(st store.Store, service_name string, command string) error {
+	return st.Write("command", command, fmt.Sprintf("services/%s", service_name))
+}
+
+func WriteModeInStore(st store.Store, service_name string, input_mode string) error {
+	return st.Write("input_mode", input_mode, fmt.Sprintf("services/%s", service_name))
+}
+
+// Create a temp dir filled by fill, removed if it fails
+func tempDir(fill func(tmp_dir_path string) error) (string, error) {
 	tmp_dir_path, err := ioutil.TempDir("", "pipes_")
-	check(err)
-
-	// cp exec in that tmp dir
-	info, err := os.Stat(old_exec_path)
-	check(err)
-	exec_file_name = info.Name()
-	new_exec_path = path.Join(tmp_dir_path, exec_file_name)
-	/// cp cmd
-	/// TODO: check if there is no better way (buffio)
-	data, err := ioutil.ReadFile(old_exec_path)
-	check(err)
-	err = ioutil.WriteFile(new_exec_path, data, 0755)
-	check(err)
-
-	// Add pipes_client
-	p, err := utils.GetTool("pipes_client", PIPES_CLIENT)
-	check(err)
-	fmt.Println(p)
-	data, err = ioutil.ReadFile(p)
-	check(err)
-	err = ioutil.WriteFile(path.Join(tmp_dir_path, "pipes_client"), data, 0755)
-	check(err)
+	if err != nil {
+		return "", err
+	}
+	if err = fill(tmp_dir_path); err != nil {
+		os.RemoveAll(tmp_dir_path)
+		return "", err
+	}
+	return tmp_dir_path, nil
+}
 
+// Set a temp directory and cp the exec in it
+func SetTempDirectory(old_exec_path, client string) (tmp_dir_path, new_exec_path, exec_file_name string, err error) {
+	tmp_dir_path, err = tempDir(func(tmp_dir_path string) error {
+		info, err := os.Stat(old_exec_path)
+		if err != nil {
+			return err
+		}
+		exec_file_name = info.Name()
+		new_exec_path = path.Join(tmp_dir_path, exec_file_name)
+		data, err := ioutil.ReadFile(old_exec_path)
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(new_exec_path, data, 0755); err != nil {
+			return err
+		}
+		return addPipesClient(tmp_dir_path, client)
+	})
 	return
 }
 
+// Add the pipes_client in the temp dir
+func addPipesClient(tmp_dir_path, client string) error {
+	data, err := ioutil.ReadFile(client)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(tmp_dir_path, "pipes_client"), data, 0755)
+}
+
 // Create in the temp dir a Dockerfile proper to the exec type
-func CreateDockerfile(tmp_dir_path string, new_exec_path string, exec_file_name string, category categorization) (imageName string) {
+func CreateDockerfile(tmp_dir_path string, new_exec_path string, exec_file_name string, category categorization) (imageName string, err error) {
 
 	imageName_arrays := strings.SplitN(exec_file_name, ".", -1)
 	imageName = imageName_arrays[0]
@@ -204,28 +341,48 @@ func CreateDockerfile(tmp_dir_path string, new_exec_path string, exec_file_name
 
 	// write in the Dockerfile the actual content with replaced values
 	DockerfileBytesReplaced := []byte(DockerfileStringReplaced)
-	err2 := ioutil.WriteFile(newDockerfilePath, DockerfileBytesReplaced, 0644)
-	check(err2)
-
+	err = ioutil.WriteFile(newDockerfilePath, DockerfileBytesReplaced, 0644)
 	return
 }
 
-// Launch a docker build from a Dockerfile
-func DockerBuild(c *cli.Context, tmp_dir_path, imageName string) (err error) {
-	// Get store from cli
-	st, err := discovery.GetStore(c)
+// Hash of the build context, used as the default image tag
+func HashDirectory(dir_path string) (hash string, err error) {
+	h := sha256.New()
+	err = filepath.Walk(dir_path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir_path, p)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", rel, len(data))
+		h.Write(data)
+		return nil
+	})
 	if err != nil {
-		return err
+		return
 	}
+	hash = hex.EncodeToString(h.Sum(nil))[0:12]
+	return
+}
 
-	// Get swarm from store
-	sw, err := swarm.New(st)
-	if err != nil {
+// Hash of the inputs of the last build: services/<service>/hash
+func WriteHashInStore(st store.Store, service_name string, hash string) error {
+	return st.Write("hash", hash, fmt.Sprintf("services/%s", service_name))
+}
+
+// Record the build in the store:
+// services/<service>/builds/<tag> and services/<service>/latest
+func WriteBuildInStore(st store.Store, service_name string, tag string) error {
+	dir := fmt.Sprintf("services/%s/builds", service_name)
+	created := time.Now().UTC().Format(time.RFC3339)
+	if err := st.Write(tag, created, dir); err != nil {
 		return err
 	}
-
-	fmt.Printf("Building Docker image named '%s' from Dockerfile located at %s/Dockerfile\n", imageName, tmp_dir_path)
-	_, err = sw.BuildImg(imageName, tmp_dir_path)
-	check(err)
-	return
+	return st.Write("latest", tag, fmt.Sprintf("services/%s", service_name))
 }
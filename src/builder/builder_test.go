@@ -0,0 +1,100 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHashDirectory(t *testing.T) {
+	base := map[string]string{"a.py": "print(1)", "lib/b.py": "x = 2"}
+	tests := []struct {
+		name  string
+		files map[string]string
+		same  bool
+	}{
+		{"identical", map[string]string{"a.py": "print(1)", "lib/b.py": "x = 2"}, true},
+		{"content", map[string]string{"a.py": "print(2)", "lib/b.py": "x = 2"}, false},
+		{"renamed", map[string]string{"a.py": "print(1)", "lib/c.py": "x = 2"}, false},
+		{"moved", map[string]string{"a.py": "print(1)", "b.py": "x = 2"}, false},
+		{"added", map[string]string{"a.py": "print(1)", "lib/b.py": "x = 2", "c": ""}, false},
+		{"concatenated", map[string]string{"a.py": "print(1)x = 2", "lib/b.py": ""}, false},
+	}
+	dir, err := ioutil.TempDir("", "pipes_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, filepath.Join(dir, "base"), base)
+	want, err := HashDirectory(filepath.Join(dir, "base"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 12 {
+		t.Errorf("HashDirectory: got %q, want 12 characters", want)
+	}
+	for _, tt := range tests {
+		d := filepath.Join(dir, tt.name)
+		writeFiles(t, d, tt.files)
+		got, err := HashDirectory(d)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if (got == want) != tt.same {
+			t.Errorf("%s: hash %s, base %s, same = %t", tt.name, got, want, tt.same)
+		}
+	}
+}
+
+func TestDetectCategory(t *testing.T) {
+	tests := []struct {
+		file      string
+		content   string
+		execType  string
+		baseImage string
+	}{
+		{"a.py", "print(1)\n", "python", "python"},
+		{"a.PY", "print(1)\n", "python", "python"},
+		{"a.rb", "puts 1\n", "ruby", "ruby"},
+		{"a.r", "print(1)\n", "r", "r-base"},
+		{"a", "#!/usr/bin/env python3\nprint(1)\n", "python", "python:3"},
+		{"a", "#!/usr/bin/python2.7\nprint 1\n", "python", "python:2.7"},
+		{"a", "#!/usr/bin/env node\n", "node", "node"},
+		{"a", "#!/bin/bash\necho 1\n", "shell", "bash"},
+		{"a", "#!/bin/sh", "shell", "bash"},
+		{"a", "#!/usr/bin/env Rscript\n", "r", "r-base"},
+		{"a.py", "#!/usr/bin/env python2\nprint 1\n", "python", "python:2"},
+		{"a.sh", "#!/usr/bin/env ruby\n", "ruby", "ruby"},
+		{"a.py", "#!/usr/bin/env unknown\n", "python", "python"},
+		{"a", "#!\n", "binary", "microbox/scratch"},
+		{"a", "\x7fELF", "binary", "microbox/scratch"},
+	}
+	dir, err := ioutil.TempDir("", "pipes_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i, tt := range tests {
+		d := filepath.Join(dir, string(rune('a'+i)))
+		writeFiles(t, d, map[string]string{tt.file: tt.content})
+		got := detectCategory(filepath.Join(d, tt.file))
+		if got.execType != tt.execType || got.baseDockerImage != tt.baseImage {
+			t.Errorf("detectCategory(%s, %q) = %s %s, want %s %s", tt.file, tt.content,
+				got.execType, got.baseDockerImage, tt.execType, tt.baseImage)
+		}
+	}
+}
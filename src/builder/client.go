@@ -0,0 +1,154 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/francisbouvier/pipes/src/utils"
+)
+
+// Released pipes_client, at <url>/<version>/pipes_client
+const PIPES_CLIENT_URL = "https://s3-us-west-1.amazonaws.com/pipesdocker"
+
+// Sources of the pipes_client
+const PIPES_CLIENT_PKG = "github.com/francisbouvier/pipes/src/wrapper/client"
+
+// Checksum of the pipes_client released with this version of pipes,
+// set by build/release.sh with
+// -ldflags "-X github.com/francisbouvier/pipes/src/builder.PipesClientChecksum=<sha256>"
+var PipesClientChecksum string
+
+// Check a pipes_client against the checksum of the release,
+// unverified ones are only accepted when insecure
+func checkClient(p string, insecure bool) error {
+	if _, err := os.Stat(p); err != nil {
+		return err
+	}
+	var err error
+	if PipesClientChecksum == "" {
+		err = errors.New(fmt.Sprintf("No pipes_client checksum in this build of pipes to verify %s", p))
+	} else if sum, e := utils.Checksum(p); e != nil {
+		return e
+	} else if sum != PipesClientChecksum {
+		err = errors.New(fmt.Sprintf("Checksum mismatch for %s", p))
+	}
+	if err != nil && insecure {
+		log.Warnln("Using unverified pipes_client:", err)
+		return nil
+	}
+	return err
+}
+
+// Version of a pipes_client, from its --version
+func clientVersion(p string) (string, error) {
+	out, err := exec.Command(p, "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", errors.New(fmt.Sprintf("No version for %s", p))
+	}
+	return fields[len(fields)-1], nil
+}
+
+var versionRegexp = regexp.MustCompile(`VERSION = "([^"]+)"`)
+
+// Version of the sources of the pipes_client, from its main.go
+func sourceVersion() (string, error) {
+	out, err := exec.Command("go", "list", "-f", "{{.Dir}}", PIPES_CLIENT_PKG).Output()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Sources of pipes_client not found: %s", err))
+	}
+	data, err := ioutil.ReadFile(filepath.Join(strings.TrimSpace(string(out)), "main.go"))
+	if err != nil {
+		return "", err
+	}
+	m := versionRegexp.FindSubmatch(data)
+	if m == nil {
+		return "", errors.New("No version in the sources of pipes_client")
+	}
+	return string(m[1]), nil
+}
+
+// Static linux build of the pipes_client from its sources,
+// which have to be of the version of the CLI.
+// It is built apart from the cache of the released versions.
+func buildClient(version string) (string, error) {
+	v, err := sourceVersion()
+	if err != nil {
+		return "", err
+	}
+	if v != version {
+		return "", errors.New(fmt.Sprintf("Sources of pipes_client are version %s instead of %s", v, version))
+	}
+	p, err := utils.VersionedToolPath("pipes_client", "src")
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("go", "build", "-o", p, PIPES_CLIENT_PKG)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", errors.New(fmt.Sprintf("Failed to build pipes_client: %s %s", err, out))
+	}
+	return p, nil
+}
+
+// Locate the pipes_client of the version of the CLI:
+// the given one, the one next to the pipes binary (local build),
+// the cached one, one built from the sources of the same version,
+// and unless offline a downloaded one.
+// The binaries found are checked against the checksum of the release,
+// when insecure unverified ones are accepted.
+func PipesClient(version, given string, offline, insecure bool) (string, error) {
+	if given != "" {
+		if _, err := os.Stat(given); err != nil {
+			return "", err
+		}
+		log.Debugln("Using the given pipes_client:", given)
+		return given, nil
+	}
+	if bin, err := exec.LookPath(os.Args[0]); err == nil {
+		p := filepath.Join(filepath.Dir(bin), "pipes_client")
+		if _, err = os.Stat(p); err == nil {
+			// Verified before being run for its version
+			err = checkClient(p, insecure)
+			if err == nil {
+				var v string
+				if v, err = clientVersion(p); err == nil && v != version {
+					err = errors.New(fmt.Sprintf("version %s instead of %s", v, version))
+				}
+			}
+			if err == nil {
+				log.Debugln("Using pipes_client of the local build:", p)
+				return p, nil
+			}
+			log.Debugln("Ignoring pipes_client of the local build:", err)
+		}
+	}
+	p, err := utils.VersionedToolPath("pipes_client", version)
+	if err != nil {
+		return "", err
+	}
+	if _, err = os.Stat(p); err == nil && checkClient(p, insecure) == nil {
+		return p, nil
+	}
+	if p, err = buildClient(version); err == nil {
+		log.Debugln("Built pipes_client from the sources:", p)
+		return p, nil
+	}
+	log.Debugln(err)
+	if PipesClientChecksum == "" && !insecure {
+		return "", errors.New(fmt.Sprintf("pipes_client %s not found: build it from the sources, give it with --pipes-client, "+
+			"or download it unverified with --insecure-client (%s)", version, err))
+	}
+	return utils.GetVersionedTool("pipes_client", version, PIPES_CLIENT_URL, PipesClientChecksum, offline)
+}
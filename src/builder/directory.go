@@ -0,0 +1,167 @@
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Sources of a directory service in the image
+const APP_DIR = "/app"
+
+// Optional configuration of a directory service
+const CONFIG_FILE = "pipes.json"
+
+type directoryConfig struct {
+	Entrypoint string `json:"entrypoint"`
+	Command    string `json:"command"`
+	Dockerfile string `json:"dockerfile"`
+}
+
+// Dependency manifest of a directory service,
+// with the Dockerfile instructions installing the dependencies
+type manifest struct {
+	file     string
+	category categorization
+	install  string
+}
+
+var manifests = []manifest{
+	{"requirements.txt", pythonCategory, "ADD app/requirements.txt /app/\nRUN pip install -r requirements.txt"},
+	{"Gemfile", rubyCategory, "ADD app/Gemfile* /app/\nRUN bundle install"},
+	{"package.json", nodeCategory, "ADD app/package.json /app/\nRUN npm install --production"},
+}
+
+func isDirectory(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
+
+// Name of the service built from a file or a directory
+func serviceName(p string) string {
+	return filepath.Base(filepath.Clean(p))
+}
+
+func getManifest(dir string) (m manifest, ok bool) {
+	for _, m := range manifests {
+		if _, err := os.Stat(path.Join(dir, m.file)); err == nil {
+			return m, true
+		}
+	}
+	return
+}
+
+func readConfig(dir string) (cf directoryConfig, err error) {
+	data, err := ioutil.ReadFile(path.Join(dir, CONFIG_FILE))
+	if os.IsNotExist(err) {
+		return cf, nil
+	} else if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &cf)
+	return
+}
+
+// Entrypoint of a directory service: the one of pipes.json,
+// otherwise <service>.*, main.*, app.* or index.js
+func entrypoint(dir string) (string, error) {
+	cf, err := readConfig(dir)
+	if err != nil {
+		return "", err
+	}
+	if cf.Entrypoint != "" {
+		if _, err = os.Stat(path.Join(dir, cf.Entrypoint)); err != nil {
+			return "", err
+		}
+		return cf.Entrypoint, nil
+	}
+	name := strings.Split(serviceName(dir), ".")[0]
+	for _, pattern := range []string{name + ".*", "main.*", "app.*", "index.js"} {
+		matches, err := filepath.Glob(path.Join(dir, pattern))
+		if err != nil {
+			return "", err
+		}
+		for _, m := range matches {
+			if !isDirectory(m) {
+				return filepath.Base(m), nil
+			}
+		}
+	}
+	return "", errors.New(fmt.Sprintf("No entrypoint in %s, set it in %s", dir, CONFIG_FILE))
+}
+
+// Category of a directory service, from its manifest or its entrypoint
+func directoryCategory(dir string) (categorization, error) {
+	if m, ok := getManifest(dir); ok {
+		return m.category, nil
+	}
+	entry, err := entrypoint(dir)
+	if err != nil {
+		return simpleBinaryCategory, err
+	}
+	return detectCategory(path.Join(dir, entry)), nil
+}
+
+func directoryCommand(dir string, category categorization) (string, error) {
+	entry, err := entrypoint(dir)
+	if err != nil {
+		return "", err
+	}
+	return category.command + path.Join(APP_DIR, entry), nil
+}
+
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(path.Join(dst, rel), 0755)
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path.Join(dst, rel), data, info.Mode())
+	})
+}
+
+// Set a temp directory and cp the sources of the directory in app/
+func SetTempDirectoryFromDir(dir, client string) (string, error) {
+	return tempDir(func(tmp_dir_path string) error {
+		if err := copyDir(dir, path.Join(tmp_dir_path, "app")); err != nil {
+			return err
+		}
+		return addPipesClient(tmp_dir_path, client)
+	})
+}
+
+// Create in the temp dir a Dockerfile installing the dependencies of the service
+func CreateDirectoryDockerfile(tmp_dir_path, dir string, category categorization) (imageName string, err error) {
+	service_name := serviceName(dir)
+	imageName = strings.Split(service_name, ".")[0]
+
+	install := ""
+	if m, ok := getManifest(dir); ok {
+		install = m.install
+	}
+	r := strings.NewReplacer(
+		"<BASE_IMAGE>", category.baseDockerImage,
+		"<APP_DIR>", APP_DIR,
+		"<INSTALL>", install,
+	)
+	err = ioutil.WriteFile(path.Join(tmp_dir_path, "Dockerfile"), []byte(r.Replace(T_DIRECTORY)), 0644)
+	return
+}
@@ -0,0 +1,189 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// Dockerfile of a directory service, used as is
+const DOCKERFILE = "Dockerfile"
+
+// Go template of the Dockerfile of a directory service
+const DOCKERFILE_TEMPLATE = "Dockerfile.tmpl"
+
+// Data of the user templates
+type templateData struct {
+	BaseImage string
+	Service   string
+	Source    string
+	Dest      string
+	Command   string
+}
+
+// Dockerfile or template of a directory service:
+// the one of pipes.json, otherwise Dockerfile.tmpl or Dockerfile
+func userDockerfile(dir string) (p string, tmpl bool, ok bool) {
+	if !isDirectory(dir) {
+		return
+	}
+	cf, err := readConfig(dir)
+	if err == nil && cf.Dockerfile != "" {
+		p = path.Join(dir, cf.Dockerfile)
+		return p, strings.HasSuffix(p, ".tmpl"), true
+	}
+	for _, name := range []string{DOCKERFILE_TEMPLATE, DOCKERFILE} {
+		p = path.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p, name == DOCKERFILE_TEMPLATE, true
+		}
+	}
+	return "", false, false
+}
+
+func renderTemplate(p string, data templateData) (string, error) {
+	tmpl, err := template.ParseFiles(p)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Content of the Dockerfile of a directory service
+func readUserDockerfile(dir string, data templateData) (string, error) {
+	p, tmpl, ok := userDockerfile(dir)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("No Dockerfile in %s", dir))
+	}
+	if tmpl {
+		return renderTemplate(p, data)
+	}
+	content, err := ioutil.ReadFile(p)
+	return string(content), err
+}
+
+// Command of the last stage of a Dockerfile, from its ENTRYPOINT and CMD
+func dockerfileCommand(content string) string {
+	entrypoint, cmd := "", ""
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	line := ""
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		// Line continuation
+		if strings.HasSuffix(l, "\\") {
+			line += strings.TrimSuffix(l, "\\") + " "
+			continue
+		}
+		line += l
+		fields := strings.SplitN(line, " ", 2)
+		line = ""
+		if len(fields) != 2 {
+			continue
+		}
+		arg := strings.TrimSpace(fields[1])
+		var args []string
+		if json.Unmarshal([]byte(arg), &args) == nil {
+			arg = strings.Join(args, " ")
+		} else {
+			arg = strings.Join(strings.Fields(arg), " ")
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "FROM":
+			entrypoint, cmd = "", ""
+		case "ENTRYPOINT":
+			entrypoint, cmd = arg, ""
+		case "CMD":
+			cmd = arg
+		}
+	}
+	return strings.TrimSpace(entrypoint + " " + cmd)
+}
+
+// Command of a directory service with a Dockerfile:
+// the one of pipes.json, otherwise the one of the Dockerfile
+func userDockerfileCommand(dir string, category categorization) (string, error) {
+	cf, err := readConfig(dir)
+	if err != nil {
+		return "", err
+	}
+	if cf.Command != "" {
+		return cf.Command, nil
+	}
+	data := templateData{BaseImage: category.baseDockerImage, Service: serviceName(dir)}
+	content, err := readUserDockerfile(dir, data)
+	if err != nil {
+		return "", err
+	}
+	command := dockerfileCommand(content)
+	if command == "" {
+		return "", errors.New(fmt.Sprintf("No command for %s, set it in %s", dir, CONFIG_FILE))
+	}
+	return command, nil
+}
+
+// Set a temp directory and cp the directory in it,
+// the build context of the Dockerfile of the service
+func SetTempDirectoryFromUserDir(dir, client string) (string, error) {
+	return tempDir(func(tmp_dir_path string) error {
+		if err := copyDir(dir, tmp_dir_path); err != nil {
+			return err
+		}
+		return addPipesClient(tmp_dir_path, client)
+	})
+}
+
+// Write the Dockerfile in the temp dir,
+// with the pipes_client injected as ENTRYPOINT
+func writeUserDockerfile(tmp_dir_path, content string) error {
+	content = strings.TrimRight(content, "\n") + "\n" + T_PIPES_CLIENT
+	return ioutil.WriteFile(path.Join(tmp_dir_path, "Dockerfile"), []byte(content), 0644)
+}
+
+// Create in the temp dir the Dockerfile of a directory service from the user one
+func CreateUserDockerfile(tmp_dir_path, dir, command string, category categorization) (imageName string, err error) {
+	service_name := serviceName(dir)
+	imageName = strings.Split(service_name, ".")[0]
+	data := templateData{
+		BaseImage: category.baseDockerImage,
+		Service:   service_name,
+		Command:   command,
+	}
+	content, err := readUserDockerfile(dir, data)
+	if err != nil {
+		return
+	}
+	err = writeUserDockerfile(tmp_dir_path, content)
+	return
+}
+
+// Create in the temp dir the Dockerfile of an executable from the --template file
+func CreateTemplateDockerfile(tmp_dir_path, tmpl, exec_file_name, command string, category categorization) (imageName string, err error) {
+	imageName = strings.Split(exec_file_name, ".")[0]
+	data := templateData{
+		BaseImage: category.baseDockerImage,
+		Service:   exec_file_name,
+		Source:    exec_file_name,
+		Dest:      fmt.Sprintf("bin/%s", exec_file_name),
+		Command:   command,
+	}
+	content, err := renderTemplate(tmpl, data)
+	if err != nil {
+		return
+	}
+	err = writeUserDockerfile(tmp_dir_path, content)
+	return
+}
@@ -0,0 +1,101 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Go sources: a .go file or a directory of a Go package
+func isGoPackage(p string) bool {
+	if !isDirectory(p) {
+		return strings.HasSuffix(p, ".go")
+	}
+	matches, err := filepath.Glob(path.Join(p, "*.go"))
+	return err == nil && len(matches) > 0
+}
+
+// Root of the Go module of the directory, the nearest parent with a go.mod
+func goModuleRoot(dir string) (root string, ok bool) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	for {
+		if _, err := os.Stat(path.Join(root, "go.mod")); err == nil {
+			return root, true
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return "", false
+		}
+		root = parent
+	}
+}
+
+// Name of the binary of a Go service
+func goBinary(p string) string {
+	return strings.Split(serviceName(p), ".")[0]
+}
+
+// Set a temp directory and cp the Go module of the sources in app/,
+// pkg is the package to build in the module
+func SetTempDirectoryFromGo(p, client string) (tmp_dir_path, pkg string, err error) {
+	pkg = "."
+	tmp_dir_path, err = tempDir(func(tmp_dir_path string) error {
+		app := path.Join(tmp_dir_path, "app")
+		if !isDirectory(p) {
+			if err := os.MkdirAll(app, 0755); err != nil {
+				return err
+			}
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			if err = ioutil.WriteFile(path.Join(app, serviceName(p)), data, 0644); err != nil {
+				return err
+			}
+		} else if root, ok := goModuleRoot(p); ok {
+			if err := copyDir(root, app); err != nil {
+				return err
+			}
+			abs, err := filepath.Abs(p)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, abs)
+			if err != nil {
+				return err
+			}
+			pkg = "./" + filepath.ToSlash(rel)
+		} else if err := copyDir(p, app); err != nil {
+			return err
+		}
+		return addPipesClient(tmp_dir_path, client)
+	})
+	return
+}
+
+// Create in the temp dir a multi-stage Dockerfile:
+// the binary is compiled in the Go image and copied in a minimal one
+func CreateGoDockerfile(tmp_dir_path, p, pkg string, category categorization) (imageName string, err error) {
+	imageName = goBinary(p)
+	deps, modInit := "", ""
+	if _, err := os.Stat(path.Join(tmp_dir_path, "app", "go.mod")); err == nil {
+		deps = "ADD app/go.* /src/\nRUN go mod download"
+	} else {
+		modInit = "RUN go mod init " + imageName + " && go mod tidy"
+	}
+	r := strings.NewReplacer(
+		"<BASE_IMAGE>", category.baseDockerImage,
+		"<RUN_IMAGE>", simpleBinaryCategory.baseDockerImage,
+		"<DEPENDENCIES>", deps,
+		"<INIT>", modInit,
+		"<NAME>", imageName,
+		"<PACKAGE>", pkg,
+	)
+	err = ioutil.WriteFile(path.Join(tmp_dir_path, "Dockerfile"), []byte(r.Replace(T_GO)), 0644)
+	return
+}
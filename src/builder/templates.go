@@ -11,3 +11,45 @@ RUN chmod 755 bin/pipes_client
 
 ENTRYPOINT ["pipes_client"]
 `
+
+const T_DIRECTORY = `
+FROM <BASE_IMAGE>
+
+WORKDIR <APP_DIR>
+
+# Dependencies
+<INSTALL>
+
+ADD app <APP_DIR>
+
+# Get the pipes_client
+ADD pipes_client /bin/pipes_client
+RUN chmod 755 /bin/pipes_client
+
+ENTRYPOINT ["pipes_client"]
+`
+
+const T_GO = `
+# Build a static binary
+FROM <BASE_IMAGE> AS build
+WORKDIR /src
+<DEPENDENCIES>
+ADD app /src
+<INIT>
+RUN CGO_ENABLED=0 go build -ldflags "-s -w" -o /out/<NAME> <PACKAGE>
+
+FROM <RUN_IMAGE>
+COPY --from=build /out/<NAME> /bin/<NAME>
+
+# Get the pipes_client
+ADD pipes_client /bin/pipes_client
+
+ENTRYPOINT ["pipes_client"]
+`
+
+// Added to the user Dockerfiles
+const T_PIPES_CLIENT = `
+# Get the pipes_client
+ADD pipes_client /bin/pipes_client
+ENTRYPOINT ["/bin/pipes_client"]
+`
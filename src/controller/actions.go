@@ -3,16 +3,21 @@ package controller
 import (
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 	"github.com/francisbouvier/pipes/src/discovery"
 	"github.com/francisbouvier/pipes/src/orch/swarm"
 	"github.com/francisbouvier/pipes/src/store"
+	"github.com/francisbouvier/pipes/src/trace"
 )
 
-func Run(c *cli.Context) error {
+func Run(c *cli.Context) (err error) {
 	daemon := c.Bool("d")
 	if daemon {
 		log.Debugln("Running project in daemon")
@@ -29,6 +34,7 @@ func Run(c *cli.Context) error {
 		return errors.New(msg)
 	}
 	services := []string{}
+	versions := map[string]string{}
 	var query string
 	for i, service := range strings.Split(c.Args()[0], "|") {
 		if i == 0 {
@@ -39,10 +45,31 @@ func Run(c *cli.Context) error {
 			}
 		}
 		service = strings.TrimSpace(service)
+		// Services can be pinned to a version: service@version
+		service, version := ParseService(service)
+		if version != "" {
+			versions[service] = version
+		}
 		services = append(services, service)
 	}
 	// TODO: check if services exists in store
 	log.Debugln("Services", services)
+	concurrency, err := ParseConcurrency(c.StringSlice("concurrency"))
+	if err != nil {
+		return err
+	}
+	for service, _ := range concurrency {
+		found := false
+		for _, s := range services {
+			if s == service {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New(fmt.Sprintf("Service not in workflow: %s", service))
+		}
+	}
 
 	// Project
 	name := c.String("name")
@@ -50,25 +77,90 @@ func Run(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	limits := Limits{Rate: c.Int("rate"), MaxJobs: c.Int("max-jobs")}
+	if c.Bool("dry-run") {
+		opts := Options{
+			TLS:    c.Bool("tls") || c.String("tls-cert") != "",
+			Limits: limits,
+		}
+		plan, err := NewPlan(st, name, services, versions, concurrency, opts, query)
+		if err != nil {
+			return err
+		}
+		return plan.WriteTable(os.Stdout)
+	}
+	o, err := swarm.New(st)
+	if err != nil {
+		return err
+	}
 	p, err := NewProject(name, st)
 	if err != nil {
 		return err
 	}
 	log.Debugln(p)
+	ctr := Controller{orch: o, project: p, logFormat: c.GlobalString("log-format")}
+
+	// Containers already started, and the API key, are removed if the run fails
+	stopped := false
+	defer func() {
+		if err != nil && !stopped {
+			if rbErr := ctr.Rollback(); rbErr != nil {
+				log.Warnln("Rollback failed:", rbErr)
+			}
+			if p.APIKey != "" {
+				if rbErr := p.RevokeAPIKey(hashKey(p.APIKey)); rbErr != nil {
+					log.Warnln("Failed to revoke the API key:", rbErr)
+				}
+				if rbErr := discovery.SetAPIKey(c, p.ID, ""); rbErr != nil {
+					log.Warnln("Failed to delete the API key:", rbErr)
+				}
+			}
+		}
+	}()
 	if err = p.SetServices(services); err != nil {
 		return err
 	}
+	for service, version := range versions {
+		if err = p.SetVersion(service, version); err != nil {
+			return err
+		}
+	}
+
+	// Limits
+	if err = p.SetLimits(limits); err != nil {
+		return err
+	}
+	for service, n := range concurrency {
+		if err = p.SetConcurrency(service, n); err != nil {
+			return err
+		}
+	}
+
+	if c.String("tls-cert") != "" {
+		if err = p.SetTLS(c.String("tls-cert"), c.String("tls-key"), c.String("tls-ca")); err != nil {
+			return err
+		}
+	} else if c.Bool("tls") {
+		var nodes []string
+		if nodes, err = o.Nodes(); err != nil {
+			return err
+		}
+		if err = p.GenerateTLS(nodes); err != nil {
+			return err
+		}
+	}
 	log.Debugf("Project %s (%s)\n", p.ID, p.Name)
 	if daemon {
 		fmt.Printf("Project %s (%s)\n", p.ID, p.Name)
 	}
 
-	// Controller
-	o, err := swarm.New(st)
-	if err != nil {
+	// API key for the CLI
+	if p.APIKey, err = p.CreateAPIKey(); err != nil {
+		return err
+	}
+	if err = discovery.SetAPIKey(c, p.ID, p.APIKey); err != nil {
 		return err
 	}
-	ctr := Controller{orch: o, project: p}
 
 	// Run
 	api, err := ctr.LaunchAPI()
@@ -80,69 +172,152 @@ func Run(c *cli.Context) error {
 			return err
 		}
 	}
-	log.Debugf("API listening on: http://%s\n", api.Addr())
+	log.Debugf("API listening on: %s://%s\n", p.Scheme(), api.Addr())
 
 	if daemon {
-		fmt.Printf("API listening on: http://%s\n", api.Addr())
+		fmt.Printf("API listening on: %s://%s\n", p.Scheme(), api.Addr())
+		fmt.Printf("API key: %s\n", p.APIKey)
 		return nil
 	}
 
-	// Query
-	if query != "" {
-		if err = ctr.project.Query(query); err != nil {
+	// Query, from the first service arguments or from the local stdin,
+	// the result is written alone on stdout to be piped to other commands
+	if query == "" && stdinPiped() {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
 			return err
 		}
+		query = string(data)
+	}
+	var queryErr error
+	if query != "" {
+		queryErr = ctr.query(query, time.Duration(c.Int("timeout"))*time.Second)
 	}
 
 	// Stop
 	if err = ctr.Stop(); err != nil {
-		return nil
+		return err
 	}
+	stopped = true
 
-	return nil
+	return queryErr
+}
+
+// Stdin is a pipe or a file, not a terminal
+func stdinPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice == 0
+}
+
+type ProjectSummary struct {
+	ID      string
+	Name    string
+	Pipe    []string
+	Status  string
+	Created string
+}
+
+func (ps ProjectSummary) match(filters map[string][]string) bool {
+	for key, values := range filters {
+		found := false
+		for _, value := range values {
+			switch key {
+			case "name":
+				found = ps.Name == value
+			case "status":
+				found = strings.EqualFold(ps.Status, value)
+			case "service":
+				for _, service := range ps.Pipe {
+					if service == value {
+						found = true
+						break
+					}
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
 func List(c *cli.Context) error {
 	log.Debugln("Listing")
 
+	filters, err := parseFilters(c.StringSlice("filter"), "name", "status", "service")
+	if err != nil {
+		return err
+	}
 	st, err := discovery.GetStore(c)
 	if err != nil {
 		return err
 	}
 	projects, err := st.List("projects", "")
+	if err != nil {
+		return err
+	}
 
-	all := c.Bool("a")
-	fmt.Printf("PROJECT ID\t\tPIPE\t\t\t\t\tSTATUS\t\tNAME\n")
+	// A status filter replaces the running only default
+	_, status := filters["status"]
+	all := c.Bool("a") || status
+	summaries := []ProjectSummary{}
 	for _, id := range projects {
 		p, err := GetProject(id, st)
 		if err != nil {
+			log.Debugln("Invalid project:", id, err)
 			continue
 		}
-		if !all && !p.Running() {
-			continue
+		ps := ProjectSummary{ID: p.ID, Name: p.Name, Pipe: p.Services, Status: "Exited"}
+		if p.Running() {
+			ps.Status = "Running"
 		}
-		msg := p.ID[0:12]
-		msg += "\t\t"
-		pipes, err := p.GetPipes()
-		if err != nil {
-			return nil
+		ps.Created, _ = st.Read("created", fmt.Sprintf("projects/%s", p.ID))
+		if !ps.match(filters) {
+			continue
 		}
-		msg += strings.Join(pipes, " | ")
-		msg += "\t\t\t"
-		if p.Running() {
-			msg += "Running"
-		} else {
-			msg += "Exited"
+		if !all && ps.Status != "Running" {
+			continue
 		}
-		msg += "\t\t"
-		msg += p.Name
-		fmt.Println(msg)
+		summaries = append(summaries, ps)
 	}
 
-	return nil
+	return writeFormat(os.Stdout, c.String("format"), summaries, func() error {
+		fmt.Printf("PROJECT ID\t\tPIPE\t\t\t\t\tSTATUS\t\tNAME\n")
+		for _, ps := range summaries {
+			msg := ps.ID[0:12]
+			msg += "\t\t"
+			msg += strings.Join(ps.Pipe, " | ")
+			msg += "\t\t\t"
+			msg += ps.Status
+			msg += "\t\t"
+			msg += ps.Name
+			fmt.Println(msg)
+		}
+		return nil
+	})
 }
 
 func getProject(args []string, st store.Store) (*Project, error) {
+	p, err := findProject(args, st)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Running() {
+		return nil, errors.New("Project is not running")
+	}
+	log.Debugln("Project:", p.ID)
+	return p, nil
+}
+
+// Same as getProject, running or not
+func findProject(args []string, st store.Store) (*Project, error) {
 	var id string
 	var err error
 	if len(args) == 0 {
@@ -162,15 +337,23 @@ func getProject(args []string, st store.Store) (*Project, error) {
 	if err != nil {
 		return nil, errors.New("Project does not exists")
 	}
-	if !p.Running() {
-		return nil, errors.New("Project is not running")
-	}
-	log.Debugln("Project:", p.ID)
 	return p, nil
 }
 
 func Query(c *cli.Context) error {
 
+	// One source of queries
+	batch := len(c.StringSlice("file")) > 0 || c.Bool("stdin")
+	if batch && len(c.Args()) > 0 {
+		return errors.New("A query can not be given with --file or --stdin")
+	}
+	if len(c.StringSlice("file")) > 0 && c.Bool("stdin") {
+		return errors.New("--file and --stdin can not be used together")
+	}
+	if c.Bool("stdin") && !stdinPiped() {
+		return errors.New("--stdin needs a pipe or a file as stdin, not a terminal")
+	}
+
 	// Project
 	st, err := discovery.GetStore(c)
 	if err != nil {
@@ -184,6 +367,9 @@ func Query(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if p.APIKey, err = discovery.GetAPIKey(c, p.ID); err != nil {
+		log.Debugln("No API key for project:", p.ID)
+	}
 
 	// Controller
 	o, err := swarm.New(st)
@@ -191,11 +377,26 @@ func Query(c *cli.Context) error {
 		return err
 	}
 	ctr := Controller{orch: o, project: p}
+	timeout := time.Duration(c.Int("timeout")) * time.Second
+
+	// Batch of jobs
+	if batch {
+		var stdin io.Reader
+		if c.Bool("stdin") {
+			stdin = os.Stdin
+		}
+		inputs, err := BatchInputs(c.StringSlice("file"), stdin, c.Bool("per-line"))
+		if err != nil {
+			return err
+		}
+		log.Infoln("Batch of jobs:", len(inputs))
+		return writeBatchResults(ctr.project, inputs, c.Int("parallel"), timeout, c.String("output"))
+	}
 
 	// Query
 	query := strings.Join(c.Args(), " ")
 	log.Infoln("Query for:", query)
-	if err = ctr.project.Query(query); err != nil {
+	if err = ctr.project.Query(query, timeout); err != nil {
 		return err
 	}
 
@@ -229,3 +430,249 @@ func Remove(c *cli.Context) error {
 
 	return nil
 }
+
+func Restart(c *cli.Context) error {
+	st, err := discovery.GetStore(c)
+	if err != nil {
+		return err
+	}
+	p, err := findProject(c.Args(), st)
+	if err != nil {
+		return err
+	}
+	o, err := swarm.New(st)
+	if err != nil {
+		return err
+	}
+	ctr := Controller{orch: o, project: p, logFormat: c.GlobalString("log-format")}
+	if err = ctr.Restart(); err != nil {
+		return err
+	}
+	fmt.Printf("Project restarted: %s (%s)\n", p.ID, p.Name)
+	return nil
+}
+
+func Redeploy(c *cli.Context) error {
+	st, err := discovery.GetStore(c)
+	if err != nil {
+		return err
+	}
+	p, err := getProject(c.Args(), st)
+	if err != nil {
+		return err
+	}
+	services := p.Services
+	if len(c.Args()) > 1 {
+		services = []string{}
+		for _, arg := range c.Args()[1:] {
+			service, version := ParseService(arg)
+			if version != "" {
+				if err = p.SetVersion(service, version); err != nil {
+					return err
+				}
+			}
+			services = append(services, service)
+		}
+	}
+	o, err := swarm.New(st)
+	if err != nil {
+		return err
+	}
+	ctr := Controller{orch: o, project: p, logFormat: c.GlobalString("log-format")}
+	if err = ctr.Redeploy(services); err != nil {
+		return err
+	}
+	fmt.Printf("Project redeployed: %s (%s)\n", p.ID, p.Name)
+	return nil
+}
+
+func Update(c *cli.Context) error {
+	if len(c.Args()) < 2 {
+		return errors.New("You need to provide a project and a service")
+	}
+	st, err := discovery.GetStore(c)
+	if err != nil {
+		return err
+	}
+	p, err := getProject(c.Args()[0:1], st)
+	if err != nil {
+		return err
+	}
+	o, err := swarm.New(st)
+	if err != nil {
+		return err
+	}
+	ctr := Controller{
+		orch:        o,
+		project:     p,
+		logFormat:   c.GlobalString("log-format"),
+		stopTimeout: uint(c.Int("stop-timeout")),
+	}
+	timeout := time.Duration(c.Int("timeout")) * time.Second
+	for _, arg := range c.Args()[1:] {
+		service, version := ParseService(arg)
+		if version != "" {
+			if err = p.SetVersion(service, version); err != nil {
+				return err
+			}
+		}
+		if err = ctr.Update(service, timeout); err != nil {
+			return err
+		}
+		fmt.Println("Service updated:", arg)
+	}
+	return nil
+}
+
+func Metrics(c *cli.Context) error {
+	st, err := discovery.GetStore(c)
+	if err != nil {
+		return err
+	}
+	p, err := getProject(c.Args(), st)
+	if err != nil {
+		return err
+	}
+	return p.WriteMetrics(os.Stdout)
+}
+
+func Trace(c *cli.Context) error {
+	if len(c.Args()) == 0 {
+		return errors.New("You need to provide a job ID")
+	}
+	p, err := projectFromFlag(c)
+	if err != nil {
+		return err
+	}
+	traceID, err := trace.GetJob(p.Store, p.ID, c.Args()[0])
+	if err != nil {
+		return errors.New("Job does not exists")
+	}
+	spans, err := trace.Get(p.Store, p.ID, traceID)
+	if err != nil {
+		return err
+	}
+	if c.String("format") == "otlp" {
+		return trace.WriteOTLP(os.Stdout, p.ID, spans)
+	}
+
+	// Timeline, indented by depth in the pipe
+	depth := map[string]int{}
+	fmt.Printf("TRACE %s\n", traceID)
+	fmt.Printf("SERVICE\t\t\tSTART\t\tDURATION\tEXIT CODE\n")
+	for _, span := range spans {
+		d := 0
+		if parent, prs := depth[span.ParentID]; prs {
+			d = parent + 1
+		}
+		depth[span.SpanID] = d
+		name := strings.Repeat("  ", d) + span.Name
+		start := span.Start.Sub(spans[0].Start)
+		fmt.Printf("%-16s\t+%s\t\t%s\t\t%d\n", name, start, span.Duration(), span.ExitCode)
+	}
+	return nil
+}
+
+func Logs(c *cli.Context) error {
+	if len(c.Args()) == 0 {
+		return errors.New("You need to provide a project")
+	}
+	st, err := discovery.GetStore(c)
+	if err != nil {
+		return err
+	}
+	p, err := getProject(c.Args()[0:1], st)
+	if err != nil {
+		return err
+	}
+	services := append([]string{"api"}, p.Services...)
+	if len(c.Args()) > 1 {
+		services = c.Args()[1:]
+	}
+
+	// Controller
+	o, err := swarm.New(st)
+	if err != nil {
+		return err
+	}
+	ctr := Controller{orch: o, project: p}
+	return ctr.Logs(os.Stdout, services, c.Bool("f"))
+}
+
+func Inspect(c *cli.Context) error {
+	st, err := discovery.GetStore(c)
+	if err != nil {
+		return err
+	}
+	p, err := findProject(c.Args(), st)
+	if err != nil {
+		return err
+	}
+	info := p.Inspect()
+	switch c.String("format") {
+	case "table":
+		return info.WriteTable(os.Stdout)
+	case "json", "":
+		return info.WriteJSON(os.Stdout)
+	default:
+		return errors.New("Unknown format: " + c.String("format"))
+	}
+}
+
+func projectFromFlag(c *cli.Context) (*Project, error) {
+	st, err := discovery.GetStore(c)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{}
+	if c.String("name") != "" {
+		args = append(args, c.String("name"))
+	}
+	return getProject(args, st)
+}
+
+func APIKeyCreate(c *cli.Context) error {
+	p, err := projectFromFlag(c)
+	if err != nil {
+		return err
+	}
+	key, err := p.CreateAPIKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
+
+func APIKeyList(c *cli.Context) error {
+	p, err := projectFromFlag(c)
+	if err != nil {
+		return err
+	}
+	keys, err := p.ListAPIKeys()
+	if err != nil {
+		return err
+	}
+	return writeFormat(os.Stdout, c.String("format"), keys, func() error {
+		fmt.Printf("KEY ID\t\t\tCREATED\n")
+		for _, key := range keys {
+			fmt.Printf("%s\t\t%s\n", key.ID[0:12], key.Created)
+		}
+		return nil
+	})
+}
+
+func APIKeyRevoke(c *cli.Context) error {
+	if len(c.Args()) == 0 {
+		return errors.New("You need to provide a key ID")
+	}
+	p, err := projectFromFlag(c)
+	if err != nil {
+		return err
+	}
+	if err = p.RevokeAPIKey(c.Args()[0]); err != nil {
+		return err
+	}
+	fmt.Println("API key revoked:", c.Args()[0])
+	return nil
+}
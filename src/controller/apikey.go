@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/stringid"
+)
+
+type APIKey struct {
+	ID      string
+	Created string
+}
+
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// Keys are only stored hashed,
+// the clear key is returned once at creation.
+func (p *Project) CreateAPIKey() (string, error) {
+	key := stringid.GenerateRandomID()
+	dir := fmt.Sprintf("projects/%s/apikeys", p.ID)
+	created := time.Now().UTC().Format(time.RFC3339)
+	if err := p.Store.Write(hashKey(key), created, dir); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (p *Project) ListAPIKeys() ([]APIKey, error) {
+	keys := []APIKey{}
+	dir := fmt.Sprintf("projects/%s", p.ID)
+	// Created with the project key at run
+	hashes, err := p.Store.List("apikeys", dir)
+	if err != nil {
+		return keys, err
+	}
+	dir = fmt.Sprintf("%s/apikeys", dir)
+	for _, hash := range hashes {
+		created, err := p.Store.Read(hash, dir)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, APIKey{ID: hash, Created: created})
+	}
+	return keys, nil
+}
+
+func (p *Project) RevokeAPIKey(id string) error {
+	if id == "" {
+		return errors.New("API key ID is empty")
+	}
+	keys, err := p.ListAPIKeys()
+	if err != nil {
+		return err
+	}
+	// A prefix of the ID is enough, if not ambiguous
+	matches := []string{}
+	for _, key := range keys {
+		if key.ID == id {
+			matches = []string{id}
+			break
+		}
+		if strings.HasPrefix(key.ID, id) {
+			matches = append(matches, key.ID)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return errors.New("API key does not exists")
+	case 1:
+		dir := fmt.Sprintf("projects/%s/apikeys", p.ID)
+		return p.Store.Delete(matches[0], dir)
+	}
+	return errors.New(fmt.Sprintf("API key prefix %s matches %d keys", id, len(matches)))
+}
+
+func (p *Project) CheckAPIKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	dir := fmt.Sprintf("projects/%s/apikeys", p.ID)
+	_, err := p.Store.Read(hashKey(key), dir)
+	return err == nil
+}
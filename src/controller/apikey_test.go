@@ -0,0 +1,28 @@
+package controller
+
+import "testing"
+
+func TestRevokeAPIKey(t *testing.T) {
+	st := newMemStore()
+	p := &Project{ID: "p1", Store: st}
+	dir := "projects/p1/apikeys"
+	st.Write("abc1", "created", dir)
+	st.Write("abc2", "created", dir)
+	st.Write("def1", "created", dir)
+
+	for _, id := range []string{"", "abc", "xyz"} {
+		if err := p.RevokeAPIKey(id); err == nil {
+			t.Errorf("RevokeAPIKey(%q): expected an error", id)
+		}
+	}
+	if err := p.RevokeAPIKey("def"); err != nil {
+		t.Fatalf("RevokeAPIKey(%q): %s", "def", err)
+	}
+	if err := p.RevokeAPIKey("abc1"); err != nil {
+		t.Fatalf("RevokeAPIKey(%q): %s", "abc1", err)
+	}
+	keys, _ := p.ListAPIKeys()
+	if len(keys) != 1 || keys[0].ID != "abc2" {
+		t.Errorf("Remaining keys = %v, want only abc2", keys)
+	}
+}
@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Input of a batch, submitted as a separate job
+type BatchInput struct {
+	Name  string
+	Query string
+}
+
+type BatchResult struct {
+	Input  BatchInput
+	Result string
+	Err    error
+}
+
+func readLines(r io.Reader, name string) ([]BatchInput, error) {
+	inputs := []BatchInput{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		inputs = append(inputs, BatchInput{Name: fmt.Sprintf("%s.%d", name, n), Query: line})
+	}
+	return inputs, scanner.Err()
+}
+
+func readInputFile(p string, perLine bool) ([]BatchInput, error) {
+	if perLine {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readLines(f, filepath.Base(p))
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return []BatchInput{{Name: filepath.Base(p), Query: string(data)}}, nil
+}
+
+// Inputs of a batch: each file (each file of the directories), or the stdin.
+// With perLine each of their non empty lines.
+func BatchInputs(files []string, stdin io.Reader, perLine bool) ([]BatchInput, error) {
+	inputs := []BatchInput{}
+	if stdin != nil {
+		if perLine {
+			return readLines(stdin, "stdin")
+		}
+		data, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return nil, err
+		}
+		return []BatchInput{{Name: "stdin", Query: string(data)}}, nil
+	}
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		paths := []string{f}
+		if info.IsDir() {
+			entries, err := ioutil.ReadDir(f)
+			if err != nil {
+				return nil, err
+			}
+			paths = []string{}
+			for _, e := range entries {
+				if !e.IsDir() {
+					paths = append(paths, filepath.Join(f, e.Name()))
+				}
+			}
+			sort.Strings(paths)
+		}
+		for _, p := range paths {
+			in, err := readInputFile(p, perLine)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, in...)
+		}
+	}
+	return inputs, nil
+}
+
+// Submit the inputs as separate jobs, at most parallel at once.
+// The results are handled in the order of the inputs,
+// no more jobs are submitted once handle fails.
+func (p *Project) Batch(inputs []BatchInput, parallel int, timeout time.Duration, handle func(BatchResult) error) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]BatchResult, len(inputs))
+	done := make([]chan bool, len(inputs))
+	for i := range done {
+		done[i] = make(chan bool)
+	}
+	indexes := make(chan int)
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				res := BatchResult{Input: inputs[i]}
+				job, err := p.Submit(inputs[i].Query)
+				if err == nil {
+					res.Result, err = p.Wait(job, timeout)
+				}
+				res.Err = err
+				results[i] = res
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		defer close(indexes)
+		for i := range inputs {
+			select {
+			case indexes <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var err error
+	for i := range inputs {
+		<-done[i]
+		if err = handle(results[i]); err != nil {
+			close(stop)
+			break
+		}
+	}
+	wg.Wait()
+	return err
+}
+
+// Write the results to stdout, one per line at least, or as is to a file per input in the output dir,
+// the errors to stderr
+func writeBatchResults(p *Project, inputs []BatchInput, parallel int, timeout time.Duration, output string) error {
+	if output != "" {
+		names := map[string]bool{}
+		for _, in := range inputs {
+			if names[in.Name] {
+				return errors.New(fmt.Sprintf("Several inputs named %s in %s", in.Name, output))
+			}
+			names[in.Name] = true
+		}
+		if err := os.MkdirAll(output, 0755); err != nil {
+			return err
+		}
+	}
+	failed := 0
+	err := p.Batch(inputs, parallel, timeout, func(res BatchResult) error {
+		if res.Err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %s\n", res.Input.Name, res.Err)
+			return nil
+		}
+		if output == "" {
+			// Keep the results of the stream apart, without blank lines
+			result := res.Result
+			if !strings.HasSuffix(result, "\n") {
+				result += "\n"
+			}
+			_, err := io.WriteString(os.Stdout, result)
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(output, res.Input.Name), []byte(res.Result), 0644)
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return errors.New(fmt.Sprintf("%d of %d jobs failed", failed, len(inputs)))
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// In memory store, keys are the full paths
+type memStore struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+func newMemStore() *memStore {
+	return &memStore{keys: map[string]string{}}
+}
+
+func memKey(key, dir string) string {
+	return strings.Trim(dir+"/"+key, "/")
+}
+
+func (st *memStore) Initialize(string, []string) error { return nil }
+func (st *memStore) New(string)                        {}
+func (st *memStore) Addr() string                      { return "" }
+
+func (st *memStore) Read(key, dir string) (string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	value, prs := st.keys[memKey(key, dir)]
+	if !prs {
+		return "", errors.New("Key not found")
+	}
+	return value, nil
+}
+
+func (st *memStore) List(key, dir string) ([]string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	prefix := memKey(key, dir) + "/"
+	keys := []string{}
+	for k := range st.keys {
+		if strings.HasPrefix(k, prefix) && !strings.Contains(k[len(prefix):], "/") {
+			keys = append(keys, k[len(prefix):])
+		}
+	}
+	return keys, nil
+}
+
+func (st *memStore) Write(key, value, dir string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.keys[memKey(key, dir)] = value
+	return nil
+}
+
+func (st *memStore) WriteTTL(key, value, dir string, ttl time.Duration) error {
+	return st.Write(key, value, dir)
+}
+
+func (st *memStore) Delete(key, dir string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.keys, memKey(key, dir))
+	return nil
+}
+
+func TestReadLines(t *testing.T) {
+	inputs, err := readLines(strings.NewReader("a\n\n  b c \nd"), "in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BatchInput{{"in.1", "a"}, {"in.3", "b c"}, {"in.4", "d"}}
+	if !reflect.DeepEqual(inputs, want) {
+		t.Errorf("readLines = %v, want %v", inputs, want)
+	}
+}
+
+func TestBatchInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipes_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{"d/b": "2\n3\n", "d/a": "1", "d/sub/c": "x", "e": "4"}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err = ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths := []string{filepath.Join(dir, "d"), filepath.Join(dir, "e")}
+	tests := []struct {
+		name    string
+		files   []string
+		stdin   string
+		perLine bool
+		want    []BatchInput
+	}{
+		{"files", paths, "", false, []BatchInput{{"a", "1"}, {"b", "2\n3\n"}, {"e", "4"}}},
+		{"files per line", paths, "", true, []BatchInput{{"a.1", "1"}, {"b.1", "2"}, {"b.2", "3"}, {"e.1", "4"}}},
+		{"stdin", nil, "x\ny\n", false, []BatchInput{{"stdin", "x\ny\n"}}},
+		{"stdin per line", nil, "x\ny\n", true, []BatchInput{{"stdin.1", "x"}, {"stdin.2", "y"}}},
+	}
+	for _, tt := range tests {
+		var stdin io.Reader
+		if tt.files == nil {
+			stdin = strings.NewReader(tt.stdin)
+		}
+		inputs, err := BatchInputs(tt.files, stdin, tt.perLine)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(inputs, tt.want) {
+			t.Errorf("%s: BatchInputs = %v, want %v", tt.name, inputs, tt.want)
+		}
+	}
+	if _, err = BatchInputs([]string{filepath.Join(dir, "missing")}, nil, false); err == nil {
+		t.Errorf("BatchInputs of a missing file: expected an error")
+	}
+}
+
+// API answering the upper case of the queries,
+// the slow ones being answered later
+type stubAPI struct {
+	mu        sync.Mutex
+	submitted int
+	results   map[string]string
+}
+
+func (api *stubAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" && strings.HasPrefix(r.FormValue("query"), "slow") {
+		time.Sleep(300 * time.Millisecond)
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if r.Method == "POST" {
+		query := r.FormValue("query")
+		api.submitted++
+		job := fmt.Sprintf("%d", api.submitted)
+		api.results[job] = strings.ToUpper(query)
+		fmt.Fprintf(w, "Job ID: %s\n", job)
+		return
+	}
+	job := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	result := api.results[job]
+	if result == "ERR" {
+		fmt.Fprintf(w, "Job ID: %s\nJob status: Error\nJob error: failed\n", job)
+		return
+	}
+	fmt.Fprintf(w, "Job ID: %s\nJob status: Success\nJob result: %s\n", job, result)
+}
+
+func testProject(api *stubAPI) (*Project, func()) {
+	server := httptest.NewServer(api)
+	st := newMemStore()
+	p := &Project{ID: "p1", Name: "test", Store: st}
+	st.Write("running", "true", "projects/p1")
+	st.Write("addr", strings.TrimPrefix(server.URL, "http://"), "projects/p1/services/api")
+	return p, server.Close
+}
+
+func TestBatchOrder(t *testing.T) {
+	api := &stubAPI{results: map[string]string{}}
+	p, stop := testProject(api)
+	defer stop()
+
+	inputs := []BatchInput{}
+	want := []string{}
+	for i := 0; i < 10; i++ {
+		q := fmt.Sprintf("q%d", i)
+		if i < 2 {
+			q = fmt.Sprintf("slow%d", i)
+		}
+		inputs = append(inputs, BatchInput{Name: q, Query: q})
+		want = append(want, strings.ToUpper(q))
+	}
+	inputs[3].Query = "err"
+	want[3] = ""
+	got := []string{}
+	failed := 0
+	err := p.Batch(inputs, 4, 5*time.Second, func(res BatchResult) error {
+		if res.Err != nil {
+			failed++
+		}
+		got = append(got, res.Result)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Batch results = %v, want %v", got, want)
+	}
+	if failed != 1 {
+		t.Errorf("Batch failed jobs = %d, want 1", failed)
+	}
+}
+
+func TestBatchStop(t *testing.T) {
+	api := &stubAPI{results: map[string]string{}}
+	p, stop := testProject(api)
+	defer stop()
+
+	inputs := []BatchInput{}
+	for i := 0; i < 10; i++ {
+		inputs = append(inputs, BatchInput{Name: fmt.Sprintf("%d", i), Query: "q"})
+	}
+	handled := 0
+	err := p.Batch(inputs, 1, 5*time.Second, func(res BatchResult) error {
+		handled++
+		return errors.New("write failed")
+	})
+	if err == nil {
+		t.Errorf("Batch: expected the error of handle")
+	}
+	if handled != 1 {
+		t.Errorf("Batch handled %d results, want 1", handled)
+	}
+	if api.submitted > 2 {
+		t.Errorf("Batch submitted %d jobs after the error, want at most 2", api.submitted)
+	}
+}
@@ -1,18 +1,44 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/francisbouvier/pipes/src/discovery"
 	"github.com/francisbouvier/pipes/src/engine"
+	"github.com/francisbouvier/pipes/src/metrics"
 	"github.com/francisbouvier/pipes/src/orch"
+	"github.com/francisbouvier/pipes/src/registry"
+	"github.com/francisbouvier/pipes/src/store"
 )
 
 type Controller struct {
-	orch    orch.Orch
-	project *Project
+	orch        orch.Orch
+	project     *Project
+	logFormat   string
+	stopTimeout uint // Seconds given to the containers to drain their jobs
+}
+
+func (ctr *Controller) logger(service string) *log.Entry {
+	return log.WithFields(log.Fields{
+		"project": ctr.project.ID,
+		"service": service,
+	})
+}
+
+// Command of the pipes_api and pipes_client containers
+func (ctr *Controller) cmd(args ...string) []string {
+	cmd := []string{"-l", "debug"}
+	if ctr.logFormat != "" {
+		cmd = append(cmd, "--log-format", ctr.logFormat)
+	}
+	cmd = append(cmd, ctr.project.Store.Addr(), ctr.project.ID)
+	return append(cmd, args...)
 }
 
 func (ctr *Controller) LaunchAPI() (*engine.Container, error) {
@@ -25,75 +51,163 @@ func (ctr *Controller) LaunchAPI() (*engine.Container, error) {
 		Image:    img,
 		Ports: []map[string]string{
 			map[string]string{port: ""},
+			map[string]string{metrics.PORT: ""},
 		},
-		Cmd: []string{
-			"-l", "debug",
-			ctr.project.Store.Addr(),
-			ctr.project.ID,
-		},
+		Cmd: ctr.cmd(),
 	}
-	err := ctr.orch.Run(container)
-	if err != nil {
-		return container, nil
+	runErr := ctr.orch.Run(container)
+	// Recorded first to be removed on rollback,
+	// even if created but not started
+	if container.Id != "" {
+		if err := ctr.project.SetContainer("api", container); err != nil {
+			return container, err
+		}
+	}
+	if runErr != nil {
+		return container, runErr
 	}
 	dir := fmt.Sprintf("projects/%s/services/api", ctr.project.ID)
-	err = ctr.project.Store.Write("addr", container.Addr(), dir)
-	if err = ctr.project.SetContainer("api", container); err != nil {
+	if err := ctr.project.Store.Write("addr", container.Addr(), dir); err != nil {
+		return container, err
+	}
+	if err := ctr.project.SetMetrics("api", container); err != nil {
 		return container, err
 	}
-	log.Infoln("Running API on:", container.Addr())
-	return container, err
+	ctr.logger("api").WithField("container", container.Id).Infoln("Running API on:", container.Addr())
+	return container, nil
 }
 
 func (ctr *Controller) launchService(service string) error {
-	log.Infoln("Running:", service)
 	name := fmt.Sprintf("%s_%s", ctr.project.Name, service)
-	imgName := strings.Split(service, ".")[0]
-	img := engine.Image{Name: imgName}
+	_, err := ctr.runService(service, name)
+	return err
+}
+
+func (ctr *Controller) runService(service, name string) (*engine.Container, error) {
+	ctr.logger(service).Infoln("Running:", service)
+	img := serviceImage(ctr.project.Store, service, ctr.project.GetVersion(service))
 
 	// Run
-	cmd := []string{
-		"-l", "debug",
-		ctr.project.Store.Addr(),
-		ctr.project.ID, service,
-	}
+	cmd := ctr.cmd(service)
 	container := &engine.Container{
 		Name:     name,
 		Hostname: name,
 		Image:    img,
-		Ports:    []map[string]string{},
-		Cmd:      cmd,
+		Ports: []map[string]string{
+			map[string]string{metrics.PORT: ""},
+		},
+		Cmd: cmd,
+	}
+	runErr := ctr.orch.Run(container)
+	// Recorded first to be removed on rollback,
+	// even if created but not started
+	if container.Id != "" {
+		if err := ctr.project.SetContainer(service, container); err != nil {
+			return container, err
+		}
+	}
+	if runErr != nil {
+		return container, runErr
+	}
+	if err := ctr.project.SetMetrics(service, container); err != nil {
+		return container, err
+	}
+	ctr.logger(service).WithField("container", container.Id).Infoln("Running on:", container.IP)
+	return container, nil
+}
+
+// Image of a service, pinned to its version, from the registry if any
+func serviceImage(st store.Store, service, version string) engine.Image {
+	imgName := strings.Split(service, ".")[0]
+	if version != "" {
+		imgName = fmt.Sprintf("%s:%s", imgName, version)
 	}
-	if err := ctr.orch.Run(container); err != nil {
+	if reg, err := registry.Get(st); err == nil {
+		return engine.Image{Name: reg.Image(imgName), Registry: true}
+	}
+	return engine.Image{Name: imgName}
+}
+
+// Submit the query once the services are ready,
+// the result is written as is on stdout
+func (ctr *Controller) query(query string, timeout time.Duration) error {
+	if err := ctr.waitServices(timeout); err != nil {
 		return err
 	}
-	if err := ctr.project.SetContainer(service, container); err != nil {
+	job, err := ctr.project.Submit(query)
+	if err != nil {
+		return err
+	}
+	result, err := ctr.project.Wait(job, timeout)
+	if err != nil {
 		return err
 	}
+	_, err = io.WriteString(os.Stdout, result)
+	return err
+}
+
+// Wait until the services have registered their procedure on the router
+func (ctr *Controller) waitServices(timeout time.Duration) error {
+	for _, service := range ctr.project.Services {
+		name := fmt.Sprintf("%s_%s", ctr.project.Name, service)
+		if err := ctr.project.WaitReady(service, name, timeout); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (ctr *Controller) stopService(service string) error {
-	log.Infoln("Stopping:", service)
-	name := fmt.Sprintf("%s_%s", ctr.project.Name, service)
-	container, err := ctr.project.GetContainer(service)
-	if err != nil {
+func (ctr *Controller) removeContainer(service string, container *engine.Container) error {
+	ctr.logger(service).WithField("container", container.Id).Infoln("Stopping:", service)
+	container.StopTimeout = ctr.stopTimeout
+	if err := ctr.orch.Stop(container); err != nil {
 		return err
 	}
-	container.Name = name
-	if err = ctr.orch.Stop(container); err != nil {
+	if err := ctr.orch.Remove(container); err != nil {
 		return err
 	}
-	if err = ctr.orch.Remove(container); err != nil {
+	return ctr.project.RemoveContainer(service, container)
+}
+
+func (ctr *Controller) stopService(service string) error {
+	containers, err := ctr.project.GetContainers(service)
+	if err != nil {
+		// No containers
+		return nil
+	}
+	for _, container := range containers {
+		if err = ctr.removeContainer(service, container); err != nil {
+			return err
+		}
+	}
+	return ctr.project.ClearReady(service, "")
+}
+
+func (ctr *Controller) Stop() error {
+	for _, service := range ctr.project.Services {
+		if err := ctr.stopService(service); err != nil {
+			return err
+		}
+	}
+	if err := ctr.stopService("api"); err != nil {
 		return err
 	}
-	if err = ctr.project.RemoveContainer(service, container); err != nil {
+	if err := ctr.project.Stop(); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (ctr *Controller) Stop() error {
+// Undo a failed run: remove the containers already started
+// and mark the project as not running
+func (ctr *Controller) Rollback() error {
+	log.WithField("project", ctr.project.ID).Warnln("Rolling back project:", ctr.project.Name)
+	return ctr.Stop()
+}
+
+// Relaunch the API and the services of the project,
+// from the topology in the store
+func (ctr *Controller) Restart() error {
 	for _, service := range ctr.project.Services {
 		if err := ctr.stopService(service); err != nil {
 			return err
@@ -102,8 +216,86 @@ func (ctr *Controller) Stop() error {
 	if err := ctr.stopService("api"); err != nil {
 		return err
 	}
-	if err := ctr.project.Stop(); err != nil {
+	if err := ctr.project.Start(); err != nil {
 		return err
 	}
+	if _, err := ctr.LaunchAPI(); err != nil {
+		return err
+	}
+	for _, service := range ctr.project.Services {
+		if err := ctr.launchService(service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replace the containers of the services,
+// using the last image built for each service
+func (ctr *Controller) Redeploy(services []string) error {
+	for _, service := range services {
+		found := false
+		for _, s := range ctr.project.Services {
+			if s == service {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New(fmt.Sprintf("Service not in project: %s", service))
+		}
+	}
+	for _, service := range services {
+		if err := ctr.stopService(service); err != nil {
+			return err
+		}
+		if err := ctr.launchService(service); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// Replace the containers of a service without downtime:
+// the new container is started and has to register its procedure
+// on the router before the old ones are stopped.
+func (ctr *Controller) Update(service string, timeout time.Duration) error {
+	found := false
+	for _, s := range ctr.project.Services {
+		if s == service {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New(fmt.Sprintf("Service not in project: %s", service))
+	}
+	old, err := ctr.project.GetContainers(service)
+	if err != nil {
+		return err
+	}
+
+	// New container
+	name := fmt.Sprintf("%s_%s_%d", ctr.project.Name, service, time.Now().Unix())
+	container, err := ctr.runService(service, name)
+	if err != nil {
+		return err
+	}
+	if err = ctr.project.WaitReady(service, name, timeout); err != nil {
+		// Keep the old containers
+		if e := ctr.removeContainer(service, container); e != nil {
+			ctr.logger(service).WithField("container", container.Id).Warnln("Failed to remove:", e)
+		}
+		ctr.project.ClearMarks(service, name)
+		return err
+	}
+	ctr.logger(service).WithField("container", container.Id).Infoln("Ready:", name)
+
+	// Old containers drain their jobs before exiting
+	for _, cont := range old {
+		if err = ctr.removeContainer(service, cont); err != nil {
+			return err
+		}
+	}
+	return ctr.project.ClearReady(service, name)
+}
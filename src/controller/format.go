@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Write a list of items as json, yaml or with a Go template
+// applied to each item (like docker ps --format).
+// The table function is used for the default format.
+func writeFormat(w io.Writer, format string, items interface{}, table func() error) error {
+	switch format {
+	case "", "table":
+		return table()
+	case "json":
+		data, err := json.MarshalIndent(items, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	case "yaml":
+		return writeYAML(w, items)
+	}
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice {
+		return errors.New("Template format needs a list")
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err = tmpl.Execute(w, v.Index(i).Interface()); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// YAML through the JSON representation of the items,
+// enough for the flat records of the listing commands
+func writeYAML(w io.Writer, items interface{}) error {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	var v interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	yamlValue(w, v, "", false)
+	return nil
+}
+
+func yamlScalar(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		if s == "" || strings.ContainsAny(s, ":#{}[],&*!|>'\"%@`\n") ||
+			strings.ContainsAny(s[:1], "-?") || strings.TrimSpace(s) != s {
+			return strconv.Quote(s)
+		}
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return strconv.Quote(s)
+		}
+		switch strings.ToLower(s) {
+		case "true", "false", "yes", "no", "null", "~":
+			return strconv.Quote(s)
+		}
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func yamlValue(w io.Writer, v interface{}, indent string, inList bool) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 {
+			fmt.Fprintln(w, "{}")
+			return
+		}
+		keys := []string{}
+		for k, _ := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for i, k := range keys {
+			prefix := indent
+			if inList && i == 0 {
+				prefix = ""
+			}
+			switch child := value[k].(type) {
+			case map[string]interface{}, []interface{}:
+				if reflect.ValueOf(child).Len() == 0 {
+					fmt.Fprintf(w, "%s%s: ", prefix, k)
+					yamlValue(w, child, indent, false)
+					continue
+				}
+				fmt.Fprintf(w, "%s%s:\n", prefix, k)
+				yamlValue(w, child, indent+"  ", false)
+			default:
+				fmt.Fprintf(w, "%s%s: %s\n", prefix, k, yamlScalar(child))
+			}
+		}
+	case []interface{}:
+		if len(value) == 0 {
+			fmt.Fprintln(w, "[]")
+			return
+		}
+		for i, elem := range value {
+			prefix := indent
+			if inList && i == 0 {
+				prefix = ""
+			}
+			fmt.Fprintf(w, "%s- ", prefix)
+			switch elem.(type) {
+			case map[string]interface{}, []interface{}:
+				yamlValue(w, elem, indent+"  ", true)
+			default:
+				fmt.Fprintln(w, yamlScalar(elem))
+			}
+		}
+	default:
+		fmt.Fprintln(w, yamlScalar(value))
+	}
+}
+
+// Filters given as key=value, values of the same key are alternatives
+func parseFilters(filters []string, keys ...string) (map[string][]string, error) {
+	m := map[string][]string{}
+	for _, f := range filters {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return m, errors.New(fmt.Sprintf("Invalid filter: %s", f))
+		}
+		valid := false
+		for _, k := range keys {
+			if k == kv[0] {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return m, errors.New(fmt.Sprintf("Invalid filter: %s", kv[0]))
+		}
+		m[kv[0]] = append(m[kv[0]], kv[1])
+	}
+	return m, nil
+}
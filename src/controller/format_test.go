@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilters(t *testing.T) {
+	tests := []struct {
+		filters []string
+		want    map[string][]string
+		err     bool
+	}{
+		{nil, map[string][]string{}, false},
+		{[]string{"name=a"}, map[string][]string{"name": {"a"}}, false},
+		{[]string{"status=running", "status=exited"}, map[string][]string{"status": {"running", "exited"}}, false},
+		{[]string{"name=a=b"}, map[string][]string{"name": {"a=b"}}, false},
+		{[]string{"name"}, nil, true},
+		{[]string{"other=a"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFilters(tt.filters, "name", "status")
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseFilters(%q): expected an error", tt.filters)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFilters(%q): %s", tt.filters, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFilters(%q) = %v, want %v", tt.filters, got, tt.want)
+		}
+	}
+}
+
+func TestProjectSummaryMatch(t *testing.T) {
+	ps := ProjectSummary{Name: "p1", Pipe: []string{"a", "b"}, Status: "Running"}
+	tests := []struct {
+		filters map[string][]string
+		want    bool
+	}{
+		{map[string][]string{}, true},
+		{map[string][]string{"name": {"p1"}}, true},
+		{map[string][]string{"name": {"p2"}}, false},
+		{map[string][]string{"name": {"p2", "p1"}}, true},
+		{map[string][]string{"status": {"running"}}, true},
+		{map[string][]string{"status": {"exited"}}, false},
+		{map[string][]string{"service": {"b"}}, true},
+		{map[string][]string{"service": {"c"}}, false},
+		{map[string][]string{"name": {"p1"}, "service": {"c"}}, false},
+	}
+	for _, tt := range tests {
+		if got := ps.match(tt.filters); got != tt.want {
+			t.Errorf("match(%v) = %t, want %t", tt.filters, got, tt.want)
+		}
+	}
+}
+
+func TestYAMLScalar(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, "null"},
+		{"plain", "plain"},
+		{"", `""`},
+		{"a: b", `"a: b"`},
+		{"-x", `"-x"`},
+		{"?x", `"?x"`},
+		{"#x", `"#x"`},
+		{"{x", `"{x"`},
+		{" x", `" x"`},
+		{"12", `"12"`},
+		{"true", `"true"`},
+		{float64(3), "3"},
+		{true, "true"},
+	}
+	for _, tt := range tests {
+		if got := yamlScalar(tt.value); got != tt.want {
+			t.Errorf("yamlScalar(%#v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/francisbouvier/pipes/src/trace"
+)
+
+// Number of jobs used for the recent job stats
+const RECENT_JOBS = 20
+
+type ContainerInfo struct {
+	ID    string
+	IP    string
+	Ports map[string]string
+}
+
+type ServiceInfo struct {
+	Name        string
+	Version     string `json:",omitempty"`
+	Next        []string
+	Command     string `json:",omitempty"`
+	InputMode   string `json:",omitempty"`
+	Concurrency int
+	Metrics     string `json:",omitempty"`
+	Containers  []ContainerInfo
+}
+
+type Options struct {
+	TLS    bool
+	Limits Limits
+}
+
+type JobStats struct {
+	Total           int
+	Recent          int
+	Success         int
+	Error           int
+	Running         int
+	AverageDuration string
+}
+
+type ProjectInfo struct {
+	ID       string
+	Name     string
+	Running  bool
+	Created  string
+	Pipe     []string
+	Realm    string
+	API      string
+	Router   string
+	Options  Options
+	Services []ServiceInfo
+	Jobs     JobStats
+}
+
+func (p *Project) serviceInfo(service string) ServiceInfo {
+	dir := fmt.Sprintf("projects/%s/services/%s", p.ID, service)
+	info := ServiceInfo{Name: service, Next: []string{}, Containers: []ContainerInfo{}}
+	if next, err := p.Store.List("next", dir); err == nil {
+		info.Next = append(info.Next, next...)
+	}
+	info.Command, _ = p.Store.Read("command", fmt.Sprintf("services/%s", service))
+	info.InputMode, _ = p.Store.Read("input_mode", fmt.Sprintf("services/%s", service))
+	info.Version = p.GetVersion(service)
+	info.Concurrency = p.GetConcurrency(service)
+	info.Metrics, _ = p.GetMetrics(service)
+	containers, _ := p.GetContainers(service)
+	for _, cont := range containers {
+		c := ContainerInfo{ID: cont.Id, IP: cont.IP, Ports: map[string]string{}}
+		for _, m := range cont.Ports {
+			for k, v := range m {
+				c.Ports[k] = v
+			}
+		}
+		info.Containers = append(info.Containers, c)
+	}
+	return info
+}
+
+// Stats of the most recent jobs, from the API spans
+func (p *Project) JobStats() JobStats {
+	stats := JobStats{}
+	dir := fmt.Sprintf("projects/%s", p.ID)
+	jobs, err := p.Store.List("jobs", dir)
+	if err != nil {
+		return stats
+	}
+	stats.Total = len(jobs)
+	roots := []*trace.Span{}
+	for _, job := range jobs {
+		traceID, err := trace.GetJob(p.Store, p.ID, job)
+		if err != nil {
+			continue
+		}
+		// The API span is recorded when the job ends
+		spans, _ := trace.Get(p.Store, p.ID, traceID)
+		finished := false
+		for _, span := range spans {
+			if span.ParentID == "" {
+				roots = append(roots, span)
+				finished = true
+			}
+		}
+		if !finished {
+			stats.Running++
+		}
+	}
+	sort.Sort(sort.Reverse(byStartTime(roots)))
+	if len(roots) > RECENT_JOBS {
+		roots = roots[:RECENT_JOBS]
+	}
+	var total time.Duration
+	for _, span := range roots {
+		if span.ExitCode == 0 {
+			stats.Success++
+		} else {
+			stats.Error++
+		}
+		total += span.Duration()
+	}
+	stats.Recent = len(roots)
+	if len(roots) > 0 {
+		stats.AverageDuration = (total / time.Duration(len(roots))).String()
+	}
+	return stats
+}
+
+type byStartTime []*trace.Span
+
+func (b byStartTime) Len() int           { return len(b) }
+func (b byStartTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byStartTime) Less(i, j int) bool { return b[i].Start.Before(b[j].Start) }
+
+func (p *Project) Inspect() ProjectInfo {
+	dir := fmt.Sprintf("projects/%s", p.ID)
+	info := ProjectInfo{
+		ID:       p.ID,
+		Name:     p.Name,
+		Running:  p.Running(),
+		Pipe:     p.Services,
+		Realm:    p.Realm,
+		Services: []ServiceInfo{},
+	}
+	info.Created, _ = p.Store.Read("created", dir)
+	info.API, _ = p.Store.Read("addr", fmt.Sprintf("%s/services/api", dir))
+	if info.API != "" {
+		info.API = fmt.Sprintf("%s://%s", p.Scheme(), info.API)
+	}
+	info.Router, _ = p.Store.Read("addr", "router")
+	info.Options = Options{
+		TLS:    p.TLS(),
+		Limits: p.GetLimits(),
+	}
+	for _, service := range append([]string{"api"}, p.Services...) {
+		info.Services = append(info.Services, p.serviceInfo(service))
+	}
+	info.Jobs = p.JobStats()
+	return info
+}
+
+func (info ProjectInfo) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(info, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (info ProjectInfo) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	status := "Exited"
+	if info.Running {
+		status = "Running"
+	}
+	fmt.Fprintf(tw, "ID\t%s\n", info.ID)
+	fmt.Fprintf(tw, "NAME\t%s\n", info.Name)
+	fmt.Fprintf(tw, "STATUS\t%s\n", status)
+	fmt.Fprintf(tw, "CREATED\t%s\n", info.Created)
+	fmt.Fprintf(tw, "PIPE\t%s\n", strings.Join(info.Pipe, " | "))
+	fmt.Fprintf(tw, "API\t%s\n", info.API)
+	fmt.Fprintf(tw, "ROUTER\t%s\n", info.Router)
+	fmt.Fprintf(tw, "REALM\t%s\n", info.Realm)
+	fmt.Fprintf(tw, "OPTIONS\ttls=%t rate=%d max-jobs=%d\n",
+		info.Options.TLS,
+		info.Options.Limits.Rate, info.Options.Limits.MaxJobs)
+	fmt.Fprintf(tw, "JOBS\ttotal=%d running=%d recent=%d success=%d error=%d average=%s\n",
+		info.Jobs.Total, info.Jobs.Running, info.Jobs.Recent,
+		info.Jobs.Success, info.Jobs.Error, info.Jobs.AverageDuration)
+	fmt.Fprintf(tw, "\nSERVICE\tNEXT\tCONTAINER\tNODE\tPORTS\n")
+	for _, s := range info.Services {
+		next := strings.Join(s.Next, ",")
+		if len(s.Containers) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t-\t-\t-\n", s.Name, next)
+		}
+		for _, c := range s.Containers {
+			ports := []string{}
+			for k, v := range c.Ports {
+				ports = append(ports, fmt.Sprintf("%s->%s", v, k))
+			}
+			sort.Strings(ports)
+			id := c.ID
+			if len(id) > 12 {
+				id = id[0:12]
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Name, next, id, c.IP, strings.Join(ports, ","))
+		}
+	}
+	return tw.Flush()
+}
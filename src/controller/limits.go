@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits of the project API, 0 means unlimited
+type Limits struct {
+	Rate    int // Requests per second
+	MaxJobs int // Jobs in flight
+}
+
+func (p *Project) SetLimits(l Limits) error {
+	dir := fmt.Sprintf("projects/%s/limits", p.ID)
+	if err := p.Store.Write("rate", strconv.Itoa(l.Rate), dir); err != nil {
+		return err
+	}
+	return p.Store.Write("max_jobs", strconv.Itoa(l.MaxJobs), dir)
+}
+
+func (p *Project) readInt(key, dir string) int {
+	value, err := p.Store.Read(key, dir)
+	if err != nil {
+		return 0
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+func (p *Project) GetLimits() Limits {
+	dir := fmt.Sprintf("projects/%s/limits", p.ID)
+	return Limits{
+		Rate:    p.readInt("rate", dir),
+		MaxJobs: p.readInt("max_jobs", dir),
+	}
+}
+
+// Maximum concurrent executions of a service, 0 means unlimited
+func (p *Project) SetConcurrency(service string, n int) error {
+	dir := fmt.Sprintf("projects/%s/services/%s", p.ID, service)
+	return p.Store.Write("concurrency", strconv.Itoa(n), dir)
+}
+
+func (p *Project) GetConcurrency(service string) int {
+	dir := fmt.Sprintf("projects/%s/services/%s", p.ID, service)
+	return p.readInt("concurrency", dir)
+}
+
+// Parse concurrency caps given as service=n
+func ParseConcurrency(caps []string) (map[string]int, error) {
+	m := map[string]int{}
+	for _, c := range caps {
+		kv := strings.SplitN(c, "=", 2)
+		if len(kv) != 2 {
+			return m, errors.New(fmt.Sprintf("Invalid concurrency: %s", c))
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil || n < 0 {
+			return m, errors.New(fmt.Sprintf("Invalid concurrency: %s", c))
+		}
+		m[strings.TrimSpace(kv[0])] = n
+	}
+	return m, nil
+}
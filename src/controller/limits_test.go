@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConcurrency(t *testing.T) {
+	tests := []struct {
+		caps []string
+		want map[string]int
+		err  bool
+	}{
+		{nil, map[string]int{}, false},
+		{[]string{"a=4"}, map[string]int{"a": 4}, false},
+		{[]string{"a=4", "b=0"}, map[string]int{"a": 4, "b": 0}, false},
+		{[]string{" a =2"}, map[string]int{"a": 2}, false},
+		{[]string{"a"}, nil, true},
+		{[]string{"a=x"}, nil, true},
+		{[]string{"a=-1"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseConcurrency(tt.caps)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseConcurrency(%q): expected an error", tt.caps)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseConcurrency(%q): %s", tt.caps, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseConcurrency(%q) = %v, want %v", tt.caps, got, tt.want)
+		}
+	}
+}
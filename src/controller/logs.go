@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/francisbouvier/pipes/src/engine"
+)
+
+type logLine struct {
+	service   string
+	timestamp string
+	msg       string
+}
+
+// Split the logs of a container into lines
+type lineWriter struct {
+	service string
+	lines   chan<- logLine
+	buf     []byte
+}
+
+func (lw *lineWriter) Write(b []byte) (int, error) {
+	lw.buf = append(lw.buf, b...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i == -1 {
+			break
+		}
+		lw.send(string(lw.buf[:i]))
+		lw.buf = lw.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (lw *lineWriter) flush() {
+	if len(lw.buf) > 0 {
+		lw.send(string(lw.buf))
+		lw.buf = nil
+	}
+}
+
+func (lw *lineWriter) send(line string) {
+	// Docker prefixes each line by its timestamp
+	l := logLine{service: lw.service, msg: line}
+	if parts := strings.SplitN(line, " ", 2); len(parts) == 2 {
+		l.timestamp, l.msg = parts[0], parts[1]
+	}
+	lw.lines <- l
+}
+
+type byTimestamp []logLine
+
+func (b byTimestamp) Len() int           { return len(b) }
+func (b byTimestamp) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byTimestamp) Less(i, j int) bool { return b[i].timestamp < b[j].timestamp }
+
+// Write the logs of the containers of each service, interleaved.
+// Without follow the logs are sorted by timestamp,
+// otherwise they are written as they arrive.
+func (ctr *Controller) Logs(w io.Writer, services []string, follow bool) error {
+	width := 0
+	containers := map[string][]*engine.Container{}
+	for _, service := range services {
+		if len(service) > width {
+			width = len(service)
+		}
+		conts, err := ctr.project.GetContainers(service)
+		if err != nil {
+			return err
+		}
+		containers[service] = conts
+	}
+
+	lines := make(chan logLine)
+	var wg sync.WaitGroup
+	for service, conts := range containers {
+		for _, container := range conts {
+			wg.Add(1)
+			lw := &lineWriter{service: service, lines: lines}
+			go func(service string, lw *lineWriter, cont *engine.Container) {
+				defer wg.Done()
+				if err := ctr.orch.Logs(cont, lw, follow); err != nil {
+					log.Infoln("Failed to get logs:", service, err)
+				}
+				lw.flush()
+			}(service, lw, container)
+		}
+	}
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+
+	format := fmt.Sprintf("%%s %%-%ds | %%s\n", width)
+	if follow {
+		for l := range lines {
+			fmt.Fprintf(w, format, l.timestamp, l.service, l.msg)
+		}
+		return nil
+	}
+	all := []logLine{}
+	for l := range lines {
+		all = append(all, l)
+	}
+	sort.Stable(byTimestamp(all))
+	for _, l := range all {
+		fmt.Fprintf(w, format, l.timestamp, l.service, l.msg)
+	}
+	return nil
+}
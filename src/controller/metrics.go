@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/francisbouvier/pipes/src/engine"
+	"github.com/francisbouvier/pipes/src/metrics"
+)
+
+func (p *Project) SetMetrics(service string, cont *engine.Container) error {
+	dir := fmt.Sprintf("projects/%s/services/%s", p.ID, service)
+	return p.Store.Write("metrics", cont.PortAddr(metrics.PORT), dir)
+}
+
+func (p *Project) GetMetrics(service string) (string, error) {
+	dir := fmt.Sprintf("projects/%s/services/%s", p.ID, service)
+	return p.Store.Read("metrics", dir)
+}
+
+type family struct {
+	header  []string
+	samples []string
+}
+
+// Add the service label to a sample line
+func withService(line, service string) string {
+	label := fmt.Sprintf("service=%q", service)
+	if i := strings.Index(line, "{"); i != -1 && i < strings.Index(line, " ") {
+		return line[:i+1] + label + "," + line[i+1:]
+	}
+	i := strings.Index(line, " ")
+	if i == -1 {
+		return line
+	}
+	return line[:i] + "{" + label + "}" + line[i:]
+}
+
+// Fetch the metrics of each service of the project
+// and write them merged, with a service label
+func (p *Project) WriteMetrics(w io.Writer) error {
+	families := map[string]*family{}
+	order := []string{}
+	for _, service := range append([]string{"api"}, p.Services...) {
+		addr, err := p.GetMetrics(service)
+		if err != nil {
+			log.Debugln("No metrics for:", service)
+			continue
+		}
+		resp, err := http.Get(fmt.Sprintf("http://%s/metrics", addr))
+		if err != nil {
+			log.Infoln("Failed to get metrics:", service, err)
+			continue
+		}
+		var current *family
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				continue
+			}
+			if strings.HasPrefix(line, "# ") {
+				fields := strings.Fields(line)
+				if len(fields) < 3 {
+					continue
+				}
+				name := fields[2]
+				f, prs := families[name]
+				if !prs {
+					f = &family{}
+					families[name] = f
+					order = append(order, name)
+				}
+				if len(f.header) < 2 {
+					f.header = append(f.header, line)
+				}
+				current = f
+				continue
+			}
+			if current != nil {
+				current.samples = append(current.samples, withService(line, service))
+			}
+		}
+		resp.Body.Close()
+		if err = scanner.Err(); err != nil {
+			return err
+		}
+	}
+	for _, name := range order {
+		f := families[name]
+		for _, line := range append(f.header, f.samples...) {
+			fmt.Fprintln(w, line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/francisbouvier/pipes/src/discovery"
+	"github.com/francisbouvier/pipes/src/orch/swarm"
+	"github.com/francisbouvier/pipes/src/store"
+)
+
+// Container to be created by a run
+type PlannedContainer struct {
+	Service     string
+	Name        string
+	Image       string
+	Version     string
+	Nodes       string
+	Concurrency int // 0 is unlimited
+	Built       bool
+}
+
+// What a run would do, printed by pipes run --dry-run
+type Plan struct {
+	Project    string
+	Pipe       []string
+	Query      string
+	Options    Options
+	Containers []PlannedContainer
+}
+
+// Nodes where the image of a container can be scheduled
+func planNodes(sw swarm.Swarm, image string, registry bool) string {
+	if registry {
+		return "any (pulled from the registry)"
+	}
+	if nodes, err := sw.ImageNodes(image); err == nil && len(nodes) > 0 {
+		return strings.Join(nodes, ",")
+	}
+	return "any holding the image"
+}
+
+func NewPlan(st store.Store, name string, services []string, versions map[string]string,
+	concurrency map[string]int, opts Options, query string) (*Plan, error) {
+	sw, err := swarm.New(st)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = "<random>"
+	}
+	plan := &Plan{Project: name, Pipe: services, Query: query, Options: opts}
+	plan.Containers = append(plan.Containers, PlannedContainer{
+		Service: "api",
+		Name:    fmt.Sprintf("%s_api", name),
+		Image:   discovery.API_IMAGE,
+		Nodes:   "any",
+		Built:   true,
+	})
+	for _, service := range services {
+		version := versions[service]
+		if version != "" {
+			if err = checkVersion(st, service, version); err != nil {
+				return nil, err
+			}
+		}
+		img := serviceImage(st, service, version)
+		_, err := st.Read("command", fmt.Sprintf("services/%s", service))
+		plan.Containers = append(plan.Containers, PlannedContainer{
+			Service:     service,
+			Name:        fmt.Sprintf("%s_%s", name, service),
+			Image:       img.Name,
+			Version:     version,
+			Nodes:       planNodes(sw, img.Name, img.Registry),
+			Concurrency: concurrency[service],
+			Built:       err == nil,
+		})
+	}
+	return plan, nil
+}
+
+func (plan *Plan) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "PROJECT\t%s\n", plan.Project)
+	fmt.Fprintf(tw, "PIPE\t%s\n", strings.Join(plan.Pipe, " | "))
+	if plan.Query != "" {
+		fmt.Fprintf(tw, "QUERY\t%s\n", plan.Query)
+	}
+	fmt.Fprintf(tw, "OPTIONS\ttls=%t rate=%d max-jobs=%d\n",
+		plan.Options.TLS,
+		plan.Options.Limits.Rate, plan.Options.Limits.MaxJobs)
+	fmt.Fprintf(tw, "\nSERVICE\tCONTAINER\tIMAGE\tNODES\tCONCURRENCY\tBUILT\n")
+	for _, cont := range plan.Containers {
+		n := "-"
+		if cont.Concurrency > 0 {
+			n = fmt.Sprintf("%d", cont.Concurrency)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\n",
+			cont.Service, cont.Name, cont.Image, cont.Nodes, n, cont.Built)
+	}
+	return tw.Flush()
+}
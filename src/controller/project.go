@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -17,11 +18,18 @@ import (
 	"github.com/francisbouvier/pipes/src/store"
 )
 
+const DEFAULT_REALM = "realm1"
+
 type Project struct {
 	ID       string
 	Name     string
+	Realm    string
+	APIKey   string
 	Store    store.Store
 	Services []string
+
+	client   *http.Client
+	clientMu sync.Mutex
 }
 
 func NewProject(name string, st store.Store) (*Project, error) {
@@ -46,6 +54,15 @@ func NewProject(name string, st store.Store) (*Project, error) {
 	if err = p.Store.Write("running", "true", dir); err != nil {
 		return p, err
 	}
+	created := time.Now().UTC().Format(time.RFC3339)
+	if err = p.Store.Write("created", created, dir); err != nil {
+		return p, err
+	}
+	// Each project gets its own realm on the Wamp router
+	p.Realm = fmt.Sprintf("realm.%s", p.ID)
+	if err = p.Store.Write("realm", p.Realm, dir); err != nil {
+		return p, err
+	}
 	if err = p.Store.Write("services", "", dir); err != nil {
 		return p, err
 	}
@@ -66,10 +83,31 @@ func GetProject(id string, st store.Store) (*Project, error) {
 	if err != nil {
 		return p, err
 	}
-	p.Services, err = p.Store.List("services", dir)
+	services, err := p.Store.List("services", dir)
 	if err != nil {
 		return p, err
 	}
+	// In the order of the pipe, the services out of it
+	// (or of a project without next links) come after
+	p.Services, err = p.GetPipes()
+	if err != nil {
+		p.Services = []string{}
+	}
+	inPipe := map[string]bool{}
+	for _, service := range p.Services {
+		inPipe[service] = true
+	}
+	// Without the API, as in SetServices
+	for _, service := range services {
+		if service != "api" && !inPipe[service] {
+			p.Services = append(p.Services, service)
+		}
+	}
+	p.Realm, err = p.Store.Read("realm", dir)
+	if err != nil {
+		// Projects created before dedicated realms
+		p.Realm = DEFAULT_REALM
+	}
 	return p, nil
 }
 
@@ -82,6 +120,12 @@ func (p *Project) nextService(service string, next []string, err error) ([]strin
 	if len(services) == 0 {
 		return next, nil
 	}
+	// A service used twice in the pipe loops
+	for _, s := range next {
+		if s == services[0] {
+			return next, nil
+		}
+	}
 	next = append(next, services[0])
 	return p.nextService(services[0], next, err)
 }
@@ -101,6 +145,19 @@ func (p *Project) SetContainer(service string, container *engine.Container) erro
 	if err := p.Store.Write(container.Id, container.IP, dir); err != nil {
 		return err
 	}
+	// Ports as <container port>:<host port>
+	ports := []string{}
+	for _, m := range container.Ports {
+		for k, v := range m {
+			ports = append(ports, fmt.Sprintf("%s:%s", k, v))
+		}
+	}
+	if len(ports) > 0 {
+		dir = fmt.Sprintf("projects/%s/services/%s/ports/", p.ID, service)
+		if err := p.Store.Write(container.Id, strings.Join(ports, ","), dir); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -116,8 +173,146 @@ func (p *Project) GetContainer(service string) (*engine.Container, error) {
 	return &engine.Container{Id: contID[0]}, nil
 }
 
+func (p *Project) GetContainers(service string) ([]*engine.Container, error) {
+	containers := []*engine.Container{}
+	dir := fmt.Sprintf("projects/%s/services/%s", p.ID, service)
+	contIDs, err := p.Store.List("containers", dir)
+	if err != nil {
+		return containers, err
+	}
+	portsDir := fmt.Sprintf("%s/ports", dir)
+	dir = fmt.Sprintf("%s/containers", dir)
+	for _, id := range contIDs {
+		ip, _ := p.Store.Read(id, dir)
+		cont := &engine.Container{Id: id, IP: ip, Ports: []map[string]string{}}
+		if ports, err := p.Store.Read(id, portsDir); err == nil {
+			for _, port := range strings.Split(ports, ",") {
+				kv := strings.SplitN(port, ":", 2)
+				if len(kv) == 2 {
+					cont.Ports = append(cont.Ports, map[string]string{kv[0]: kv[1]})
+				}
+			}
+		}
+		containers = append(containers, cont)
+	}
+	return containers, nil
+}
+
+// Split a service of a pipe as service@version
+func ParseService(s string) (name, version string) {
+	parts := strings.SplitN(s, "@", 2)
+	name = parts[0]
+	if len(parts) == 2 {
+		version = parts[1]
+	}
+	return
+}
+
+// Pin the service to a version built by pipes build,
+// empty version is the latest
+func (p *Project) SetVersion(service, version string) error {
+	dir := fmt.Sprintf("projects/%s/services/%s", p.ID, service)
+	if version == "" {
+		p.Store.Delete("version", dir)
+		return nil
+	}
+	if err := checkVersion(p.Store, service, version); err != nil {
+		return err
+	}
+	return p.Store.Write("version", version, dir)
+}
+
+// The version has to be a build of the service
+func checkVersion(st store.Store, service, version string) error {
+	builds := fmt.Sprintf("services/%s/builds", service)
+	if _, err := st.Read(version, builds); err != nil {
+		return errors.New(fmt.Sprintf("Unknown version %s of %s", version, service))
+	}
+	return nil
+}
+
+func (p *Project) GetVersion(service string) string {
+	dir := fmt.Sprintf("projects/%s/services/%s", p.ID, service)
+	version, _ := p.Store.Read("version", dir)
+	return version
+}
+
+// Containers of a service mark themselves ready
+// once their procedure is registered on the router
+func (p *Project) SetReady(service, name string) error {
+	dir := fmt.Sprintf("projects/%s/services/%s/ready", p.ID, service)
+	return p.Store.Write(name, time.Now().UTC().Format(time.RFC3339), dir)
+}
+
+func (p *Project) IsReady(service, name string) bool {
+	dir := fmt.Sprintf("projects/%s/services/%s/ready", p.ID, service)
+	_, err := p.Store.Read(name, dir)
+	return err == nil
+}
+
+// Mark the container name as failed to register its procedure
+func (p *Project) SetFailed(service, name, reason string) error {
+	dir := fmt.Sprintf("projects/%s/services/%s/failed", p.ID, service)
+	return p.Store.Write(name, reason, dir)
+}
+
+func (p *Project) IsFailed(service, name string) (string, bool) {
+	dir := fmt.Sprintf("projects/%s/services/%s/failed", p.ID, service)
+	reason, err := p.Store.Read(name, dir)
+	return reason, err == nil
+}
+
+// Wait until the container name of the service is ready,
+// or has failed to register
+func (p *Project) WaitReady(service, name string, timeout time.Duration) error {
+	const interval = 200 * time.Millisecond
+	for start := time.Now(); time.Since(start) < timeout; {
+		if p.IsReady(service, name) {
+			return nil
+		}
+		if reason, failed := p.IsFailed(service, name); failed {
+			return errors.New(fmt.Sprintf("Service %s failed: %s", service, reason))
+		}
+		time.Sleep(interval)
+	}
+	return errors.New(fmt.Sprintf("Service %s not ready after %s", service, timeout))
+}
+
+// Remove the marks of the container name
+func (p *Project) ClearMarks(service, name string) {
+	// Marks are optional
+	dir := fmt.Sprintf("projects/%s/services/%s", p.ID, service)
+	p.Store.Delete(name, dir+"/ready")
+	p.Store.Delete(name, dir+"/failed")
+}
+
+// Remove the ready and failed marks, except the ones of keep
+func (p *Project) ClearReady(service, keep string) error {
+	for _, mark := range []string{"ready", "failed"} {
+		dir := fmt.Sprintf("projects/%s/services/%s", p.ID, service)
+		names, err := p.Store.List(mark, dir)
+		if err != nil {
+			// No marks
+			continue
+		}
+		dir = fmt.Sprintf("%s/%s", dir, mark)
+		for _, name := range names {
+			if name == keep {
+				continue
+			}
+			if err = p.Store.Delete(name, dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (p *Project) RemoveContainer(service string, cont *engine.Container) error {
-	dir := fmt.Sprintf("projects/%s/services/%s/containers/", p.ID, service)
+	// Ports are optional
+	dir := fmt.Sprintf("projects/%s/services/%s/ports/", p.ID, service)
+	p.Store.Delete(cont.Id, dir)
+	dir = fmt.Sprintf("projects/%s/services/%s/containers/", p.ID, service)
 	return p.Store.Delete(cont.Id, dir)
 }
 
@@ -159,76 +354,130 @@ func (p *Project) Running() bool {
 	return status
 }
 
-func (p *Project) Query(query string) error {
+func (p *Project) do(req *http.Request) (*http.Response, error) {
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+	client, err := p.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
 
+func (p *Project) Query(query string, timeout time.Duration) error {
+	job, err := p.Submit(query)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Waiting results ...")
+	result, err := p.Wait(job, timeout)
+	if err != nil {
+		return err
+	}
+	fmt.Println(result)
+	return nil
+}
+
+// Address of the API of the running project
+func (p *Project) api() (string, error) {
 	// Check running
 	if running := p.Running(); running == false {
-		return errors.New("Project is not running")
+		return "", errors.New("Project is not running")
 	}
-
-	// API
 	dir := fmt.Sprintf("projects/%s/services/api", p.ID)
-	api, err := p.Store.Read("addr", dir)
+	return p.Store.Read("addr", dir)
+}
+
+// Post a query to the API, returns the ID of its job
+func (p *Project) Submit(query string) (string, error) {
+	api, err := p.api()
 	if err != nil {
-		return err
+		return "", err
 	}
 	// TODO: use wamp client instead of long polling
 
 	// Post query
 	form := url.Values{}
 	form.Set("query", query)
-	resp1, err := http.PostForm("http://"+api, form)
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s", p.Scheme(), api), strings.NewReader(form.Encode()))
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer resp1.Body.Close()
-	body, err := ioutil.ReadAll(resp1.Body)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := p.do(req)
 	if err != nil {
-		return err
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
 	}
 	job := strings.TrimPrefix(string(body), "Job ID: ")
 	job = strings.TrimSuffix(job, "\n")
-	log.Infof("API response: [%d] - Job %s", resp1.StatusCode, job)
-	if resp1.StatusCode != 200 {
-		msg := fmt.Sprintf("API error: %s", resp1.StatusCode)
-		return errors.New(msg)
+	log.Infof("API response: [%d] - Job %s", resp.StatusCode, job)
+	if resp.StatusCode != 200 {
+		msg := fmt.Sprintf("API error: %d", resp.StatusCode)
+		return "", errors.New(msg)
 	}
+	return job, nil
+}
 
-	// Get job
-	final := false
-	const interval = 200
-	timeout := 10
-	log.Debugln("Timeout:", timeout, "seconds")
-	fmt.Println("Waiting results ...")
-	tries := (timeout * 1000) / interval
-	for i := 0; i < tries; i++ {
-		time.Sleep(interval * time.Millisecond)
-		resp, err := http.Get(fmt.Sprintf("http://%s/jobs/%s/", api, job))
+// Poll the API until the job is finished, returns its result
+func (p *Project) Wait(job string, timeout time.Duration) (string, error) {
+	api, err := p.api()
+	if err != nil {
+		return "", err
+	}
+	const interval = 200 * time.Millisecond
+	log.Debugln("Timeout:", timeout)
+	for start := time.Now(); time.Since(start) < timeout; {
+		time.Sleep(interval)
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s/jobs/%s/", p.Scheme(), api, job), nil)
 		if err != nil {
-			return err
+			return "", err
 		}
-		defer resp.Body.Close()
-		body, err = ioutil.ReadAll(resp.Body)
+		resp, err := p.do(req)
 		if err != nil {
-			return err
+			return "", err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != 200 {
+			msg := fmt.Sprintf("API error: %d", resp.StatusCode)
+			return "", errors.New(msg)
+		}
+		content := strings.SplitN(strings.TrimSuffix(string(body), "\n"), "\n", 3)
+		if len(content) < 2 {
+			continue
 		}
-		content := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
 		status := strings.TrimPrefix(content[1], "Job status: ")
 		log.Debugf("API response: [%d] - Result %s", resp.StatusCode, status)
-		if status == "Success" {
-			result := strings.TrimPrefix(content[2], "Job result: ")
-			fmt.Println(result)
-			if len(content) > 3 {
-				for _, cont := range content[2:] {
-					fmt.Println(cont)
-				}
+		switch status {
+		case "Success":
+			if len(content) < 3 {
+				return "", nil
+			}
+			return strings.TrimPrefix(content[2], "Job result: "), nil
+		case "Error":
+			msg := "Job failed"
+			if len(content) > 2 {
+				msg = strings.TrimPrefix(content[2], "Job error: ")
 			}
-			final = true
-			break
+			return "", errors.New(msg)
 		}
 	}
-	if final == false {
-		fmt.Println("Timeout")
+	return "", errors.New(fmt.Sprintf("Timeout of job %s", job))
+}
+
+func (p *Project) Start() error {
+	dir := fmt.Sprintf("projects/%s", p.ID)
+	if err := p.Store.Write("running", "true", dir); err != nil {
+		return err
 	}
 	return nil
 }
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseService(t *testing.T) {
+	tests := []struct {
+		s       string
+		name    string
+		version string
+	}{
+		{"a", "a", ""},
+		{"a@v3", "a", "v3"},
+		{"a@", "a", ""},
+		{"a.b@v1@x", "a.b", "v1@x"},
+	}
+	for _, tt := range tests {
+		name, version := ParseService(tt.s)
+		if name != tt.name || version != tt.version {
+			t.Errorf("ParseService(%q) = %q, %q, want %q, %q", tt.s, name, version, tt.name, tt.version)
+		}
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	st := newMemStore()
+	p := &Project{ID: "p1", Store: st}
+	p.SetReady("s1", "c1")
+	p.SetFailed("s1", "c2", "Failed to register")
+
+	if err := p.WaitReady("s1", "c1", time.Second); err != nil {
+		t.Errorf("WaitReady(c1): %s", err)
+	}
+	if err := p.WaitReady("s1", "c2", time.Minute); err == nil {
+		t.Error("WaitReady(c2): expected an error")
+	}
+	if err := p.WaitReady("s1", "c3", 300*time.Millisecond); err == nil {
+		t.Error("WaitReady(c3): expected a timeout")
+	}
+
+	p.ClearMarks("s1", "c2")
+	if _, failed := p.IsFailed("s1", "c2"); failed {
+		t.Error("ClearMarks(c2): still failed")
+	}
+	p.ClearReady("s1", "")
+	if p.IsReady("s1", "c1") {
+		t.Error("ClearReady: c1 still ready")
+	}
+}
+
+func TestGetProjectOrder(t *testing.T) {
+	st := newMemStore()
+	st.Write("name", "test", "projects/p1")
+	p := &Project{ID: "p1", Store: st}
+	pipe := []string{"s3", "s1", "s2"}
+	if err := p.SetServices(pipe); err != nil {
+		t.Fatal(err)
+	}
+	// Out of the pipe
+	st.Write("s0", "", "projects/p1/services")
+
+	for i := 0; i < 5; i++ {
+		got, err := GetProject("p1", st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"s3", "s1", "s2", "s0"}
+		if !reflect.DeepEqual(got.Services, want) {
+			t.Fatalf("Services = %v, want %v", got.Services, want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+)
+
+const TLS_VALIDITY = 365 * 24 * time.Hour
+
+func (p *Project) writeTLS(cert, key, ca, serverName string) error {
+	dir := fmt.Sprintf("projects/%s/tls", p.ID)
+	if err := p.Store.Write("cert", cert, dir); err != nil {
+		return err
+	}
+	if err := p.Store.Write("key", key, dir); err != nil {
+		return err
+	}
+	if err := p.Store.Write("ca", ca, dir); err != nil {
+		return err
+	}
+	if serverName == "" {
+		return nil
+	}
+	return p.Store.Write("server_name", serverName, dir)
+}
+
+// Use the certificate and key supplied by the user.
+// The CA is used by the CLI to verify the API,
+// default to the certificate itself.
+func (p *Project) SetTLS(certFile, keyFile, caFile string) error {
+	cert, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return err
+	}
+	ca := cert
+	if caFile != "" {
+		if ca, err = ioutil.ReadFile(caFile); err != nil {
+			return err
+		}
+	}
+	x509Cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	// The common name is not verified by the clients,
+	// the CLI verifies the DNS name or the IP of the API address
+	serverName := ""
+	if len(x509Cert.DNSNames) > 0 {
+		serverName = x509Cert.DNSNames[0]
+	} else if len(x509Cert.IPAddresses) == 0 {
+		return errors.New("The certificate has no subject alternative names (DNS or IP)")
+	}
+	return p.writeTLS(string(cert), string(key), string(ca), serverName)
+}
+
+// Generate a self-signed certificate for the project.
+// The API address is not known yet, so the certificate is issued
+// for the IPs of the nodes, where the API can run,
+// and the CLI verifies the IP of the API address.
+func (p *Project) GenerateTLS(nodes []string) error {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s_api", p.Name)
+	ips := []net.IP{net.ParseIP("127.0.0.1")}
+	for _, node := range nodes {
+		host, _, err := net.SplitHostPort(node)
+		if err != nil {
+			host = node
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"pipes"}},
+		DNSNames:              []string{name},
+		IPAddresses:           ips,
+		NotBefore:             now,
+		NotAfter:              now.Add(TLS_VALIDITY),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	key := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return p.writeTLS(string(cert), string(key), string(cert), "")
+}
+
+// Certificate and key for the API, in PEM format
+func (p *Project) TLSKeyPair() (cert, key []byte, err error) {
+	dir := fmt.Sprintf("projects/%s/tls", p.ID)
+	c, err := p.Store.Read("cert", dir)
+	if err != nil {
+		return
+	}
+	k, err := p.Store.Read("key", dir)
+	if err != nil {
+		return
+	}
+	return []byte(c), []byte(k), nil
+}
+
+func (p *Project) TLS() bool {
+	dir := fmt.Sprintf("projects/%s/tls", p.ID)
+	_, err := p.Store.Read("cert", dir)
+	return err == nil
+}
+
+func (p *Project) Scheme() string {
+	if p.TLS() {
+		return "https"
+	}
+	return "http"
+}
+
+// HTTP client of the API, built once per project
+// to reuse its connections
+func (p *Project) httpClient() (*http.Client, error) {
+	p.clientMu.Lock()
+	defer p.clientMu.Unlock()
+	if p.client != nil {
+		return p.client, nil
+	}
+	if !p.TLS() {
+		p.client = http.DefaultClient
+		return p.client, nil
+	}
+	dir := fmt.Sprintf("projects/%s/tls", p.ID)
+	ca, err := p.Store.Read("ca", dir)
+	if err != nil {
+		return nil, err
+	}
+	// Optional
+	serverName, _ := p.Store.Read("server_name", dir)
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM([]byte(ca)); !ok {
+		return nil, errors.New("Invalid CA for project API")
+	}
+	// Without server name the host of the API address is verified
+	config := &tls.Config{RootCAs: pool, ServerName: serverName}
+	p.client = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	return p.client, nil
+}
@@ -44,6 +44,33 @@ func (c *Conf) SetMainPool(name string) {
 	c.data["main_pool"] = name
 }
 
+func (c *Conf) keys() map[string]interface{} {
+	keys, prs := c.data["keys"]
+	if !prs {
+		keys = map[string]interface{}{}
+		c.data["keys"] = keys
+	}
+	return keys.(map[string]interface{})
+}
+
+func (c *Conf) GetKey(project string) (k string, err error) {
+	ke, prs := c.keys()[project]
+	if prs == false {
+		err = errors.New("No API key for project")
+		return
+	}
+	k = ke.(string)
+	return
+}
+
+func (c *Conf) SetKey(project, key string) {
+	c.keys()[project] = key
+}
+
+func (c *Conf) DeleteKey(project string) {
+	delete(c.keys(), project)
+}
+
 func (c *Conf) Save() error {
 	data, err := json.MarshalIndent(c.data, "", "    ")
 	if err != nil {
@@ -73,6 +100,7 @@ func getConf(c *cli.Context) (cf *Conf, err error) {
 		cf.data = map[string]interface{}{
 			"main_pool": "",
 			"pools":     map[string]interface{}{},
+			"keys":      map[string]interface{}{},
 		}
 		err = nil
 	}
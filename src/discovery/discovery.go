@@ -71,6 +71,11 @@ func Initialize(c *cli.Context) (err error) {
 		return err
 	}
 
+	// Registry
+	if err = setRegistry(c, swarm); err != nil {
+		return err
+	}
+
 	cf.SetPool(name, st.Addr())
 	cf.SetMainPool(name)
 	if err = cf.Save(); err != nil {
@@ -96,3 +101,24 @@ func GetStore(c *cli.Context) (st store.Store, err error) {
 	st.New(addr)
 	return
 }
+
+func GetAPIKey(c *cli.Context, project string) (key string, err error) {
+	cf, err := getConf(c)
+	if err != nil {
+		return
+	}
+	return cf.GetKey(project)
+}
+
+func SetAPIKey(c *cli.Context, project, key string) error {
+	cf, err := getConf(c)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		cf.DeleteKey(project)
+	} else {
+		cf.SetKey(project, key)
+	}
+	return cf.Save()
+}
@@ -43,7 +43,13 @@ func (l Local) Save(p string, data []byte) (err error) {
 	if err != nil {
 		return
 	}
-	ioutil.WriteFile(absP, data, 0644)
+	// The conf holds the API keys, keep it private
+	if err = ioutil.WriteFile(absP, data, 0600); err != nil {
+		return
+	}
+	if err = os.Chmod(absP, 0600); err != nil {
+		return
+	}
 	log.Infoln("Save conf")
 	return nil
 }
@@ -0,0 +1,68 @@
+package discovery
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+
+	"github.com/francisbouvier/pipes/src/engine"
+	"github.com/francisbouvier/pipes/src/orch/swarm"
+	"github.com/francisbouvier/pipes/src/registry"
+)
+
+// Run a local registry on the cluster
+func localRegistry(eng swarm.Swarm) (*engine.Container, error) {
+	log.Debugf("Installing registry...\n")
+
+	// Image
+	image, err := eng.GetImg(registry.IMAGE)
+	if err != nil {
+		log.Debugf("Pulling image %s...\n", registry.IMAGE)
+		image, err = eng.PullImg(registry.IMAGE, engine.Auth{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Run
+	name := "registry"
+	container := &engine.Container{
+		Name:     name,
+		Hostname: name,
+		Image:    image,
+		Ports: []map[string]string{
+			map[string]string{registry.PORT: registry.PORT},
+		},
+	}
+	if err = eng.Run(container); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Registry on node: %s\n", container.Addr())
+	return container, nil
+}
+
+// Configure the registry of the cluster,
+// a local one with --registry or an external one with --registry-addr
+func setRegistry(c *cli.Context, eng swarm.Swarm) error {
+	reg := &registry.Registry{
+		Addr: c.String("registry-addr"),
+		Auth: engine.Auth{
+			Username: c.String("registry-user"),
+			Password: c.String("registry-password"),
+			Email:    c.String("registry-email"),
+		},
+	}
+	if c.Bool("registry") {
+		container, err := localRegistry(eng)
+		if err != nil {
+			return err
+		}
+		reg.Addr = container.Addr()
+	}
+	if reg.Addr == "" {
+		return nil
+	}
+	reg.Auth.ServerAddress = reg.Addr
+	return registry.Set(eng.Store, reg)
+}
@@ -19,7 +19,7 @@ func wampRouter(eng swarm.Swarm) (*engine.Container, error) {
 	image, err := eng.GetImg(IMAGE)
 	if err != nil {
 		log.Debugf("Pulling image %s...\n", IMAGE)
-		image, err = eng.PullImg(IMAGE)
+		image, err = eng.PullImg(IMAGE, engine.Auth{})
 		if err != nil {
 			return nil, err
 		}
@@ -49,7 +49,7 @@ func wampRouter(eng swarm.Swarm) (*engine.Container, error) {
 	_, err = eng.GetImg(API_IMAGE)
 	if err != nil {
 		log.Debugf("Pulling image %s...\n", API_IMAGE)
-		_, err = eng.PullImg(API_IMAGE)
+		_, err = eng.PullImg(API_IMAGE, engine.Auth{})
 		if err != nil {
 			return nil, err
 		}
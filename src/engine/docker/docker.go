@@ -3,6 +3,7 @@ package docker
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"strings"
@@ -12,6 +13,9 @@ import (
 	dockerclient "github.com/fsouza/go-dockerclient"
 )
 
+// Default seconds before a stopped container is killed
+const STOP_TIMEOUT = 10
+
 type Docker struct {
 	client *dockerclient.Client
 }
@@ -49,6 +53,8 @@ func (d Docker) Run(cont *engine.Container) (err error) {
 	if err != nil {
 		return
 	}
+	// Known even if not started, to be removed
+	cont.Id = c.ID
 	err = d.client.StartContainer(c.ID, hostConfig)
 	if err != nil {
 		return
@@ -88,7 +94,18 @@ func (d Docker) Run(cont *engine.Container) (err error) {
 
 func (d Docker) Stop(cont *engine.Container) error {
 	log.Debugln("Stop container:", cont.Id)
-	return d.client.StopContainer(cont.Id, 10)
+	timeout := cont.StopTimeout
+	if timeout == 0 {
+		timeout = STOP_TIMEOUT
+	}
+	err := d.client.StopContainer(cont.Id, timeout)
+	switch err.(type) {
+	case *dockerclient.ContainerNotRunning, *dockerclient.NoSuchContainer:
+		// Already stopped
+		log.Debugln("Container already stopped:", cont.Id)
+		return nil
+	}
+	return err
 }
 
 func (d Docker) Remove(cont *engine.Container) error {
@@ -96,7 +113,13 @@ func (d Docker) Remove(cont *engine.Container) error {
 	opts := dockerclient.RemoveContainerOptions{
 		ID: cont.Id,
 	}
-	return d.client.RemoveContainer(opts)
+	err := d.client.RemoveContainer(opts)
+	if _, ok := err.(*dockerclient.NoSuchContainer); ok {
+		// Already removed
+		log.Debugln("Container already removed:", cont.Id)
+		return nil
+	}
+	return err
 }
 
 func (d Docker) List() (conts []*engine.Container, err error) {
@@ -120,10 +143,24 @@ func (d Docker) List() (conts []*engine.Container, err error) {
 	return
 }
 
-func (d Docker) GetImg(name string) (img engine.Image, err error) {
-	if t := strings.Index(name, ":"); t == -1 {
-		name += ":latest"
+// Write the logs of the container, prefixed by their timestamp
+func (d Docker) Logs(cont *engine.Container, w io.Writer, follow bool) error {
+	log.Debugln("Logs of container:", cont.Id)
+	opts := dockerclient.LogsOptions{
+		Container:    cont.Id,
+		OutputStream: w,
+		ErrorStream:  w,
+		Follow:       follow,
+		Stdout:       true,
+		Stderr:       true,
+		Timestamps:   true,
 	}
+	return d.client.Logs(opts)
+}
+
+func (d Docker) GetImg(name string) (img engine.Image, err error) {
+	repo, tag := engine.SplitImage(name)
+	name = fmt.Sprintf("%s:%s", repo, tag)
 	opts := dockerclient.ListImagesOptions{All: true}
 	imgs, err := d.client.ListImages(opts)
 	for _, i := range imgs {
@@ -139,20 +176,40 @@ func (d Docker) GetImg(name string) (img engine.Image, err error) {
 	return
 }
 
-func (d Docker) PullImg(name string) (img engine.Image, err error) {
-	if t := strings.Index(name, ":"); t == -1 {
-		name += ":latest"
+func authConfiguration(auth engine.Auth) dockerclient.AuthConfiguration {
+	return dockerclient.AuthConfiguration{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		Email:         auth.Email,
+		ServerAddress: auth.ServerAddress,
 	}
+}
+
+func (d Docker) PullImg(name string, auth engine.Auth) (img engine.Image, err error) {
+	repo, tag := engine.SplitImage(name)
 	opts := dockerclient.PullImageOptions{
-		Repository:   name,
-		OutputStream: os.Stdout,
+		Repository:   repo,
+		Tag:          tag,
+		OutputStream: os.Stderr,
+	}
+	if err = d.client.PullImage(opts, authConfiguration(auth)); err != nil {
+		return
 	}
-	auth := dockerclient.AuthConfiguration{}
-	err = d.client.PullImage(opts, auth)
 	return d.GetImg(name)
 }
 
-func (d Docker) BuildImg(name, dir string) (img engine.Image, err error) {
+func (d Docker) PushImg(name string, auth engine.Auth, out io.Writer) error {
+	repo, tag := engine.SplitImage(name)
+	log.Infof("Pushing image %s:%s", repo, tag)
+	opts := dockerclient.PushImageOptions{
+		Name:         repo,
+		Tag:          tag,
+		OutputStream: out,
+	}
+	return d.client.PushImage(opts, authConfiguration(auth))
+}
+
+func (d Docker) BuildImg(name, dir string, out io.Writer) (img engine.Image, err error) {
 	f := path.Join(dir, "Dockerfile")
 	if _, err = os.Stat(f); os.IsNotExist(err) {
 		return
@@ -160,7 +217,7 @@ func (d Docker) BuildImg(name, dir string) (img engine.Image, err error) {
 	log.Infof("Building image %s at %s", name, f)
 	opts := dockerclient.BuildImageOptions{
 		Name:         name,
-		OutputStream: os.Stdout,
+		OutputStream: out,
 		ContextDir:   dir,
 	}
 	if err = d.client.BuildImage(opts); err != nil {
@@ -170,6 +227,26 @@ func (d Docker) BuildImg(name, dir string) (img engine.Image, err error) {
 	return
 }
 
+// Tag the image name as dest (repository:tag)
+func (d Docker) TagImg(name, dest string) error {
+	repo, tag := engine.SplitImage(dest)
+	log.Debugf("Tag image %s as %s:%s", name, repo, tag)
+	opts := dockerclient.TagImageOptions{Repo: repo, Tag: tag, Force: true}
+	return d.client.TagImage(name, opts)
+}
+
+// Write the image as a tar archive, like docker save
+func (d Docker) SaveImg(name string, w io.Writer) error {
+	log.Debugln("Save image:", name)
+	opts := dockerclient.ExportImageOptions{Name: name, OutputStream: w}
+	return d.client.ExportImage(opts)
+}
+
+// Load an image from a tar archive, like docker load
+func (d Docker) LoadImg(r io.Reader) error {
+	return d.client.LoadImage(dockerclient.LoadImageOptions{InputStream: r})
+}
+
 func (d Docker) RemoveImg(name string) (err error) {
 	return d.client.RemoveImage(name)
 }
@@ -2,12 +2,15 @@ package engine
 
 import (
 	"fmt"
+	"io"
+	"strings"
 )
 
 type Image struct {
-	Id     string
-	Name   string
-	Manual bool
+	Id       string
+	Name     string
+	Manual   bool
+	Registry bool // Pulled from the registry of the cluster
 }
 
 type Container struct {
@@ -23,6 +26,27 @@ type Container struct {
 	Active      bool
 	NetworkMode string
 	Gateway     string
+	StopTimeout uint // Seconds before the container is killed on stop
+}
+
+// Credentials of a registry
+type Auth struct {
+	Username      string
+	Password      string
+	Email         string
+	ServerAddress string
+}
+
+// Split an image name as repository and tag,
+// the repository can contain the port of a registry
+func SplitImage(name string) (repo, tag string) {
+	repo = name
+	tag = "latest"
+	i := strings.LastIndex(name, ":")
+	if i != -1 && i > strings.LastIndex(name, "/") {
+		repo, tag = name[:i], name[i+1:]
+	}
+	return
 }
 
 // Etat démarré, pourrait être chargé depuis un json
@@ -45,13 +69,45 @@ func (cont Container) Addr() (addr string) {
 	return
 }
 
+// Address of the host port bound to a container port
+func (cont Container) PortAddr(port string) (addr string) {
+	for _, m := range cont.Ports {
+		if hostPort, prs := m[port]; prs {
+			return fmt.Sprintf("%s:%s", cont.IP, hostPort)
+		}
+	}
+	return
+}
+
 type Engine interface {
 	Run(*Container) error
 	Stop(*Container) error
 	Remove(*Container) error
 	List() ([]*Container, error)
 	GetImg(string) (Image, error)
-	PullImg(string) (Image, error)
-	BuildImg(string, string) (Image, error)
+	PullImg(string, Auth) (Image, error)
+	PushImg(string, Auth, io.Writer) error
+	BuildImg(string, string, io.Writer) (Image, error)
+	TagImg(string, string) error
+	SaveImg(string, io.Writer) error
+	LoadImg(io.Reader) error
 	RemoveImg(string) error
+	Logs(*Container, io.Writer, bool) error
+}
+
+// Copy an image from an engine to another, like docker save | docker load
+func CopyImg(name string, src, dst Engine) error {
+	r, w := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		err := src.SaveImg(name, w)
+		w.CloseWithError(err)
+		errc <- err
+	}()
+	if err := dst.LoadImg(r); err != nil {
+		r.CloseWithError(err)
+		<-errc
+		return err
+	}
+	return <-errc
 }
@@ -0,0 +1,24 @@
+package engine
+
+import "testing"
+
+func TestSplitImage(t *testing.T) {
+	tests := []struct {
+		name string
+		repo string
+		tag  string
+	}{
+		{"python", "python", "latest"},
+		{"python:3", "python", "3"},
+		{"user/service:v2", "user/service", "v2"},
+		{"localhost:5000/service", "localhost:5000/service", "latest"},
+		{"localhost:5000/service:v2", "localhost:5000/service", "v2"},
+		{"10.0.0.1:5000/user/service:abc123", "10.0.0.1:5000/user/service", "abc123"},
+	}
+	for _, tt := range tests {
+		repo, tag := SplitImage(tt.name)
+		if repo != tt.repo || tag != tt.tag {
+			t.Errorf("SplitImage(%q) = %q, %q, want %q, %q", tt.name, repo, tag, tt.repo, tt.tag)
+		}
+	}
+}
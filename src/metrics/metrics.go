@@ -0,0 +1,219 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Minimal implementation of the Prometheus text exposition format
+
+const PORT = "9090"
+
+type metric interface {
+	write(io.Writer)
+}
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+var Default = &Registry{}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.metrics {
+		m.write(w)
+	}
+}
+
+func Handler() http.Handler {
+	return Default
+}
+
+// Serve /metrics on addr, in background,
+// a failure is logged without stopping the caller
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Warnln("Failed to serve metrics on", addr+":", err)
+		}
+	}()
+}
+
+type vec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+	keys   map[string][]string
+}
+
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d labels", v.name, len(v.labels)))
+	}
+	k := strings.Join(values, "\xff")
+	if _, prs := v.keys[k]; !prs {
+		v.keys[k] = values
+	}
+	return k
+}
+
+func (v *vec) sortedKeys() []string {
+	keys := []string{}
+	for k, _ := range v.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *vec) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+}
+
+func labelPairs(names, values []string, extra ...string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, values[i]))
+	}
+	pairs = append(pairs, extra...)
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func newVec(name, help, kind string, labels []string) vec {
+	return vec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		keys:   map[string][]string{},
+	}
+}
+
+// Counter and gauge share the same storage
+type Counter struct {
+	vec
+	values map[string]float64
+}
+
+type Gauge struct {
+	Counter
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(name, help, "counter", labels), values: map[string]float64{}}
+	Default.register(c)
+	return c
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{Counter{vec: newVec(name, help, "gauge", labels), values: map[string]float64{}}}
+	Default.register(g)
+	return g
+}
+
+func (c *Counter) Add(value float64, labels ...string) {
+	c.mu.Lock()
+	c.values[c.key(labels)] += value
+	c.mu.Unlock()
+}
+
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+func (g *Gauge) Dec(labels ...string) {
+	g.Add(-1, labels...)
+}
+
+func (g *Gauge) Set(value float64, labels ...string) {
+	g.mu.Lock()
+	g.values[g.key(labels)] = value
+	g.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, k := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %g\n", c.name, labelPairs(c.labels, c.keys[k]), c.values[k])
+	}
+}
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+type Histogram struct {
+	vec
+	buckets []float64
+	counts  map[string][]uint64
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		vec:     newVec(name, help, "histogram", labels),
+		buckets: buckets,
+		counts:  map[string][]uint64{},
+		sums:    map[string]float64{},
+		totals:  map[string]uint64{},
+	}
+	Default.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labels ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := h.key(labels)
+	if _, prs := h.counts[k]; !prs {
+		h.counts[k] = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[k][i]++
+		}
+	}
+	h.sums[k] += value
+	h.totals[k]++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, k := range h.sortedKeys() {
+		values := h.keys[k]
+		for i, bound := range h.buckets {
+			le := fmt.Sprintf("le=\"%g\"", bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, values, le), h.counts[k][i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, values, "le=\"+Inf\""), h.totals[k])
+		fmt.Fprintf(w, "%s_sum%s %g\n", h.name, labelPairs(h.labels, values), h.sums[k])
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, values), h.totals[k])
+	}
+}
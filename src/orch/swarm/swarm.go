@@ -1,27 +1,48 @@
 package swarm
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/francisbouvier/pipes/src/engine"
 	"github.com/francisbouvier/pipes/src/engine/docker"
+	"github.com/francisbouvier/pipes/src/registry"
 	"github.com/francisbouvier/pipes/src/store"
 	"github.com/francisbouvier/pipes/src/utils"
 )
 
 const IMAGE = "swarm:0.3.0"
 
+// Nodes holding the images built on given nodes
+const IMAGES_DIR = "cluster/images"
+
 type Swarm struct {
 	Store  store.Store
 	engine docker.Docker
 }
 
 func (sw Swarm) Run(cont *engine.Container) (err error) {
-	// Add image affinity to ensure that image is on same node
-	aff := fmt.Sprintf("affinity:image==%s", cont.Image.Name)
-	cont.Env = append(cont.Env, aff)
+	if cont.Image.Registry {
+		// Pull on the nodes, so the container can be scheduled on any of them
+		reg, err := registry.Get(sw.Store)
+		if err != nil {
+			return err
+		}
+		if _, err = sw.engine.PullImg(cont.Image.Name, reg.Auth); err != nil {
+			return err
+		}
+	} else {
+		if err = sw.checkImageNodes(cont.Image.Name); err != nil {
+			return err
+		}
+		// Add image affinity to ensure that image is on same node
+		aff := fmt.Sprintf("affinity:image==%s", cont.Image.Name)
+		cont.Env = append(cont.Env, aff)
+	}
 	return sw.engine.Run(cont)
 }
 
@@ -41,12 +62,28 @@ func (sw Swarm) GetImg(name string) (img engine.Image, err error) {
 	return sw.engine.GetImg(name)
 }
 
-func (sw Swarm) PullImg(name string) (img engine.Image, err error) {
-	return sw.engine.PullImg(name)
+func (sw Swarm) PullImg(name string, auth engine.Auth) (img engine.Image, err error) {
+	return sw.engine.PullImg(name, auth)
+}
+
+func (sw Swarm) PushImg(name string, auth engine.Auth, out io.Writer) error {
+	return sw.engine.PushImg(name, auth, out)
+}
+
+func (sw Swarm) BuildImg(name, dir string, out io.Writer) (img engine.Image, err error) {
+	return sw.engine.BuildImg(name, dir, out)
+}
+
+func (sw Swarm) TagImg(name, dest string) error {
+	return sw.engine.TagImg(name, dest)
 }
 
-func (sw Swarm) BuildImg(name, dir string) (img engine.Image, err error) {
-	return sw.engine.BuildImg(name, dir)
+func (sw Swarm) SaveImg(name string, w io.Writer) error {
+	return sw.engine.SaveImg(name, w)
+}
+
+func (sw Swarm) LoadImg(r io.Reader) error {
+	return sw.engine.LoadImg(r)
 }
 
 func (sw Swarm) RemoveImg(name string) (err error) {
@@ -55,6 +92,71 @@ func (sw Swarm) RemoveImg(name string) (err error) {
 	return nil
 }
 
+func (sw Swarm) Logs(cont *engine.Container, w io.Writer, follow bool) error {
+	return sw.engine.Logs(cont, w, follow)
+}
+
+// Addresses of the nodes of the cluster, registered by the swarm agents
+func (sw Swarm) Nodes() ([]string, error) {
+	return sw.Store.List("nodes", "cluster/docker/swarm")
+}
+
+// Address of a node given by its IP
+func NodeAddr(addr string) string {
+	if !strings.Contains(addr, ":") {
+		addr = fmt.Sprintf("%s:%s", addr, "2375")
+	}
+	return addr
+}
+
+// Engine of a node of the cluster
+func (sw Swarm) Node(addr string) (engine.Engine, error) {
+	return docker.New("tcp://"+NodeAddr(addr), "")
+}
+
+// Record that a node holds an image:
+// cluster/images/<repository>/<tag>/<node>
+func (sw Swarm) SetImageNode(name, node string) error {
+	repo, tag := engine.SplitImage(name)
+	dir := fmt.Sprintf("%s/%s/%s", IMAGES_DIR, repo, tag)
+	return sw.Store.Write(node, time.Now().UTC().Format(time.RFC3339), dir)
+}
+
+// Forget the nodes holding an image, when it is built on any node
+func (sw Swarm) ClearImageNodes(name string) error {
+	repo, tag := engine.SplitImage(name)
+	if _, err := sw.ImageNodes(name); err != nil {
+		return nil
+	}
+	return sw.Store.Delete(tag, fmt.Sprintf("%s/%s", IMAGES_DIR, repo))
+}
+
+func (sw Swarm) ImageNodes(name string) ([]string, error) {
+	repo, tag := engine.SplitImage(name)
+	return sw.Store.List(tag, fmt.Sprintf("%s/%s", IMAGES_DIR, repo))
+}
+
+// An image built on given nodes can only run on one of them
+func (sw Swarm) checkImageNodes(name string) error {
+	holders, err := sw.ImageNodes(name)
+	if err != nil || len(holders) == 0 {
+		// Not recorded
+		return nil
+	}
+	nodes, err := sw.Nodes()
+	if err != nil {
+		return nil
+	}
+	for _, h := range holders {
+		for _, n := range nodes {
+			if h == n {
+				return nil
+			}
+		}
+	}
+	return errors.New(fmt.Sprintf("No node of the cluster holds the image %s", name))
+}
+
 func (sw Swarm) manager(server string, eng docker.Docker) (*engine.Container, error) {
 	log.Debugf("Installing Swarm manager on node %s...\n", server)
 
@@ -107,7 +209,7 @@ func (sw Swarm) agent(server string, eng docker.Docker) (*engine.Container, erro
 		Cmd:      cmd,
 	}
 	if _, err := eng.GetImg(img.Name); err != nil {
-		if _, err = eng.PullImg(img.Name); err != nil {
+		if _, err = eng.PullImg(img.Name, engine.Auth{}); err != nil {
 			return nil, err
 		}
 	}
@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+
+	"github.com/francisbouvier/pipes/src/engine"
+	"github.com/francisbouvier/pipes/src/store"
+)
+
+// Local registry of the cluster
+const (
+	IMAGE = "registry:2"
+	PORT  = "5000"
+)
+
+const DIR = "cluster/registry"
+
+// Credentials of the registries, by address. They are kept on the host
+// of the CLI, the store is readable by the containers of the services.
+const CREDENTIALS = "registry.json"
+
+// Registry where built images are pushed,
+// so services can be scheduled on any node
+type Registry struct {
+	Addr string
+	Auth engine.Auth
+}
+
+// Name of the image in the registry
+func (r *Registry) Image(name string) string {
+	return fmt.Sprintf("%s/%s", r.Addr, name)
+}
+
+func credentialsPath() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(u.HomeDir, ".pipes", CREDENTIALS), nil
+}
+
+func readCredentials() (map[string]engine.Auth, error) {
+	creds := map[string]engine.Auth{}
+	p, err := credentialsPath()
+	if err != nil {
+		return creds, err
+	}
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return creds, nil
+	} else if err != nil {
+		return creds, err
+	}
+	err = json.Unmarshal(data, &creds)
+	return creds, err
+}
+
+func writeCredentials(creds map[string]engine.Auth) error {
+	p, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(creds, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, data, 0600)
+}
+
+func Get(st store.Store) (*Registry, error) {
+	addr, err := st.Read("addr", DIR)
+	if err != nil {
+		return nil, err
+	}
+	r := &Registry{Addr: addr, Auth: engine.Auth{ServerAddress: addr}}
+	// Credentials are optional
+	creds, err := readCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if auth, prs := creds[addr]; prs {
+		r.Auth = auth
+	}
+	return r, nil
+}
+
+func Set(st store.Store, r *Registry) error {
+	if err := st.Write("addr", r.Addr, DIR); err != nil {
+		return err
+	}
+	creds, err := readCredentials()
+	if err != nil {
+		return err
+	}
+	if r.Auth.Username == "" && r.Auth.Password == "" {
+		delete(creds, r.Addr)
+	} else {
+		creds[r.Addr] = r.Auth
+	}
+	return writeCredentials(creds)
+}
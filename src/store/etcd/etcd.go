@@ -125,7 +125,7 @@ func (st *Etcd) Initialize(token string, servers []string) error {
 		container.Cmd = append(container.Cmd, "-initial-cluster", clusterAddr)
 		log.Debugln("Getting img")
 		if _, err := engines[i].GetImg(img.Name); err != nil {
-			if _, err = engines[i].PullImg(img.Name); err != nil {
+			if _, err = engines[i].PullImg(img.Name, engine.Auth{}); err != nil {
 				return err
 			}
 		}
@@ -185,6 +185,10 @@ func (st Etcd) List(key, dir string) (keys []string, err error) {
 }
 
 func (st Etcd) Write(key, value, dir string) (err error) {
+	return st.WriteTTL(key, value, dir, 0)
+}
+
+func (st Etcd) WriteTTL(key, value, dir string, ttl time.Duration) (err error) {
 	if key == "" && dir == "" {
 		return errors.New("You need to provide at least either key or dir")
 	}
@@ -193,10 +197,11 @@ func (st Etcd) Write(key, value, dir string) (err error) {
 	} else if dir != "" {
 		key = fmt.Sprintf("/%s/%s", dir, key)
 	}
+	seconds := uint64(ttl.Seconds())
 	if value != "" {
-		_, err = st.client.Set(key, value, 0)
+		_, err = st.client.Set(key, value, seconds)
 	} else {
-		_, err = st.client.CreateDir(key, 0)
+		_, err = st.client.CreateDir(key, seconds)
 	}
 	return
 }
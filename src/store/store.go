@@ -3,6 +3,7 @@ package store
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 type Store interface {
@@ -11,6 +12,8 @@ type Store interface {
 	Read(string, string) (string, error)
 	List(string, string) ([]string, error)
 	Write(string, string, string) error
+	// Write expiring after the TTL, a dir expires with its keys
+	WriteTTL(string, string, string, time.Duration) error
 	Delete(string, string) error
 	Addr() string
 }
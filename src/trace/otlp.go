@@ -0,0 +1,98 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// OpenTelemetry (OTLP/JSON) representation of spans
+
+type otlpValue struct {
+	StringValue string `json:"stringValue,omitempty"`
+	IntValue    string `json:"intValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope map[string]string `json:"scope"`
+	Spans []otlpSpan        `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   map[string][]otlpAttribute `json:"resource"`
+	ScopeSpans []otlpScopeSpans           `json:"scopeSpans"`
+}
+
+type otlpTrace struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+const (
+	otlpKindInternal = 1
+	otlpStatusOk     = 1
+	otlpStatusError  = 2
+)
+
+func WriteOTLP(w io.Writer, projectID string, spans []*Span) error {
+	out := []otlpSpan{}
+	for _, s := range spans {
+		status := otlpStatusOk
+		if s.ExitCode != 0 {
+			status = otlpStatusError
+		}
+		out = append(out, otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes: []otlpAttribute{
+				{Key: "process.exit_code", Value: otlpValue{IntValue: strconv.Itoa(s.ExitCode)}},
+			},
+			Status: otlpStatus{Code: status},
+		})
+	}
+	t := otlpTrace{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: map[string][]otlpAttribute{
+				"attributes": {
+					{Key: "service.name", Value: otlpValue{StringValue: "pipes"}},
+					{Key: "pipes.project.id", Value: otlpValue{StringValue: projectID}},
+				},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: map[string]string{"name": "pipes"},
+				Spans: out,
+			}},
+		}},
+	}
+	data, err := json.MarshalIndent(t, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
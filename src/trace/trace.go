@@ -0,0 +1,120 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/francisbouvier/pipes/src/store"
+)
+
+// Traces and jobs are removed from the store after
+const RETENTION = 24 * time.Hour
+
+// Keys of the trace context in Wamp kwargs
+const (
+	TRACE_ID       = "trace_id"
+	PARENT_SPAN_ID = "parent_span_id"
+)
+
+type Span struct {
+	TraceID  string    `json:"trace_id"`
+	SpanID   string    `json:"span_id"`
+	ParentID string    `json:"parent_span_id,omitempty"`
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exit_code"`
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start a span, child of the trace context in kwargs if any,
+// otherwise the root span of a new trace
+func StartSpan(name string, kwargs map[string]interface{}) *Span {
+	s := &Span{SpanID: randomID(8), Name: name, Start: time.Now()}
+	if id, ok := kwargs[TRACE_ID].(string); ok {
+		s.TraceID = id
+		s.ParentID, _ = kwargs[PARENT_SPAN_ID].(string)
+	} else {
+		s.TraceID = randomID(16)
+	}
+	return s
+}
+
+func (s *Span) Finish(exitCode int) {
+	s.End = time.Now()
+	s.ExitCode = exitCode
+}
+
+// Trace context to propagate to the next services
+func (s *Span) Context() map[string]interface{} {
+	return map[string]interface{}{
+		TRACE_ID:       s.TraceID,
+		PARENT_SPAN_ID: s.SpanID,
+	}
+}
+
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+func dir(projectID, traceID string) string {
+	return fmt.Sprintf("projects/%s/traces/%s", projectID, traceID)
+}
+
+func Save(st store.Store, projectID string, s *Span) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// The trace expires with its spans,
+	// it fails for the spans after the first one
+	st.WriteTTL(s.TraceID, "", fmt.Sprintf("projects/%s/traces", projectID), RETENTION)
+	return st.Write(s.SpanID, string(data), dir(projectID, s.TraceID))
+}
+
+// Jobs of the API are mapped to their trace
+func SetJob(st store.Store, projectID, jobID, traceID string) error {
+	return st.WriteTTL(jobID, traceID, fmt.Sprintf("projects/%s/jobs", projectID), RETENTION)
+}
+
+func GetJob(st store.Store, projectID, jobID string) (string, error) {
+	return st.Read(jobID, fmt.Sprintf("projects/%s/jobs", projectID))
+}
+
+// Spans of a trace, sorted by start time
+func Get(st store.Store, projectID, traceID string) ([]*Span, error) {
+	spans := []*Span{}
+	d := dir(projectID, traceID)
+	ids, err := st.List(traceID, fmt.Sprintf("projects/%s/traces", projectID))
+	if err != nil {
+		return spans, err
+	}
+	for _, id := range ids {
+		value, err := st.Read(id, d)
+		if err != nil {
+			return spans, err
+		}
+		s := &Span{}
+		if err = json.Unmarshal([]byte(value), s); err != nil {
+			return spans, err
+		}
+		spans = append(spans, s)
+	}
+	sort.Sort(byStart(spans))
+	return spans, nil
+}
+
+type byStart []*Span
+
+func (b byStart) Len() int           { return len(b) }
+func (b byStart) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byStart) Less(i, j int) bool { return b[i].Start.Before(b[j].Start) }
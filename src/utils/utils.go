@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
@@ -31,12 +34,12 @@ func download(p string, url string) error {
 	return ioutil.WriteFile(p, body, 0755)
 }
 
-func getDir() (string, error) {
+func getDir(elem ...string) (string, error) {
 	u, err := user.Current()
 	if err != nil {
 		return "", err
 	}
-	dir := path.Join(u.HomeDir, ".pipes", "tools")
+	dir := path.Join(append([]string{u.HomeDir, ".pipes", "tools"}, elem...)...)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		if err = os.MkdirAll(dir, 0755); err != nil {
 			return "", err
@@ -59,6 +62,110 @@ func GetTool(name, url string) (p string, err error) {
 	return
 }
 
+// Hex sha256 of a file
+func Checksum(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Check a file against a checksum file (sha256sum format)
+func VerifyChecksum(p, sumFile string) error {
+	data, err := ioutil.ReadFile(sumFile)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return errors.New("Empty checksum file: " + sumFile)
+	}
+	sum, err := Checksum(p)
+	if err != nil {
+		return err
+	}
+	if sum != fields[0] {
+		return errors.New("Checksum mismatch for " + p)
+	}
+	return nil
+}
+
+// Path of a tool in the cache of a version
+func VersionedToolPath(name, version string) (string, error) {
+	dir, err := getDir(version)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, name), nil
+}
+
+// Check a file against a checksum,
+// or against its checksum file when none is given
+func checkTool(p, sum string) error {
+	if sum == "" {
+		return VerifyChecksum(p, p+".sha256")
+	}
+	s, err := Checksum(p)
+	if err != nil {
+		return err
+	}
+	if s != sum {
+		return errors.New("Checksum mismatch for " + p)
+	}
+	return nil
+}
+
+// Get a tool of a version from the cache, checked against the checksum sum.
+// Unless offline, it is downloaded from <url>/<version>/<name>.
+// Without sum it is only checked against the checksum file downloaded with it.
+func GetVersionedTool(name, version, url, sum string, offline bool) (p string, err error) {
+	if p, err = VersionedToolPath(name, version); err != nil {
+		return
+	}
+	if _, err = os.Stat(p); err == nil {
+		if err = checkTool(p, sum); err == nil {
+			return
+		}
+		log.Warnln("Invalid cached tool:", err)
+	}
+	if offline {
+		return p, errors.New(name + " " + version + " not available offline")
+	}
+	toolURL := strings.Join([]string{strings.TrimSuffix(url, "/"), version, name}, "/")
+	log.Infof("Downloading %s from %s ...", name, toolURL)
+	if sum == "" {
+		if err = download(p+".sha256", toolURL+".sha256"); err != nil {
+			return
+		}
+	}
+	if err = download(p, toolURL); err != nil {
+		return
+	}
+	if err = checkTool(p, sum); err != nil {
+		os.Remove(p)
+		os.Remove(p + ".sha256")
+	}
+	return
+}
+
+func SetLogFormat(format string) error {
+	switch format {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	case "text", "":
+		log.SetFormatter(&log.TextFormatter{})
+	default:
+		return errors.New("Unknown log format: " + format)
+	}
+	return nil
+}
+
 func PickServer(pool []string) int {
 	// Chose randomly
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
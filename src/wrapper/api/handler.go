@@ -2,17 +2,80 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/francisbouvier/pipes/src/controller"
+	"github.com/francisbouvier/pipes/src/trace"
 	"github.com/francisbouvier/pipes/src/wrapper"
 	"github.com/julienschmidt/httprouter"
 )
 
+// Time after which a job is failed and its slot freed
+const JOB_TIMEOUT = 5 * time.Minute
+
 type handler struct {
-	wrapper *wrapper.Wrapper
-	jobs    map[int]*wrapper.Job
+	wrapper  *wrapper.Wrapper
+	project  *controller.Project
+	mu       sync.Mutex
+	jobs     map[int]*wrapper.Job
+	inFlight int
+	maxJobs  int
+	limiter  *limiter
+	timeout  time.Duration
+}
+
+func tooManyRequests(w http.ResponseWriter, retry float64) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry))))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+}
+
+// Reserve a slot for a new job, false if the cap is reached
+func (h *handler) reserve() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.maxJobs > 0 && h.inFlight >= h.maxJobs {
+		return false
+	}
+	h.inFlight++
+	wrapper.JobsInFlight.Inc()
+	return true
+}
+
+func (h *handler) release() {
+	h.mu.Lock()
+	h.inFlight--
+	wrapper.JobsInFlight.Dec()
+	h.mu.Unlock()
+}
+
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// Check the API key of the request before calling the handle
+func (h *handler) auth(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if !h.project.CheckAPIKey(apiKey(r)) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handle(w, r, params)
+	}
 }
 
 func (h *handler) httpAPI(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
@@ -22,6 +85,18 @@ func (h *handler) httpAPI(w http.ResponseWriter, r *http.Request, params httprou
 		return
 	}
 	log.Debugln("Query:", r.Form)
+	if h.limiter != nil {
+		if ok, wait := h.limiter.allow(); !ok {
+			wrapper.JobsTotal.Inc("rejected")
+			tooManyRequests(w, wait.Seconds())
+			return
+		}
+	}
+	if !h.reserve() {
+		wrapper.JobsTotal.Inc("rejected")
+		tooManyRequests(w, 1)
+		return
+	}
 	msg := []interface{}{}
 	query := r.Form["query"]
 	if query != nil {
@@ -29,8 +104,44 @@ func (h *handler) httpAPI(w http.ResponseWriter, r *http.Request, params httprou
 			msg = append(msg, elem)
 		}
 	}
-	job := h.wrapper.Handle(msg)
+	span := trace.StartSpan("api", nil)
+	job := h.wrapper.Handle(msg, span.Context())
+	wrapper.JobsTotal.Inc("started")
+	if err = trace.SetJob(h.project.Store, h.project.ID, strconv.Itoa(job.ID), span.TraceID); err != nil {
+		log.WithFields(log.Fields{
+			"project":  h.project.ID,
+			"service":  "api",
+			"job":      job.ID,
+			"trace_id": span.TraceID,
+		}).Infoln("Failed to record job trace:", err)
+	}
+	go func() {
+		select {
+		case <-job.Finish:
+		case <-time.After(h.timeout):
+			if job.Fail("Job timeout") {
+				log.WithFields(log.Fields{
+					"project": h.project.ID,
+					"service": "api",
+					"job":     job.ID,
+				}).Infoln("Job timeout after", h.timeout)
+			}
+		}
+		h.wrapper.Remove(job)
+		status := job.Status()
+		wrapper.JobsTotal.Inc(strings.ToLower(status.Message))
+		exit := 0
+		if status.Code == 3 {
+			exit = 1
+		}
+		span.Finish(exit)
+		h.wrapper.Record(span)
+		h.release()
+	}()
+	h.mu.Lock()
 	h.jobs[job.ID] = job
+	h.mu.Unlock()
+	w.Header().Set("X-Trace-ID", span.TraceID)
 	fmt.Fprintf(w, "Job ID: %d\n", job.ID)
 }
 
@@ -38,7 +149,9 @@ func (h *handler) httpJob(w http.ResponseWriter, r *http.Request, params httprou
 	log.Debugln("Params:", params)
 	id := params.ByName("id")
 	ID, _ := strconv.Atoi(id)
+	h.mu.Lock()
 	job, prs := h.jobs[ID]
+	h.mu.Unlock()
 	if prs == false {
 		http.NotFound(w, r)
 		return
@@ -53,13 +166,18 @@ func (h *handler) httpJob(w http.ResponseWriter, r *http.Request, params httprou
 		r := job.Responses[0].(map[string]interface{})
 		t = fmt.Sprintf("%sJob error: %s\n", t, r["error"])
 	}
-	fmt.Fprintf(w, t)
+	io.WriteString(w, t)
 }
 
-func NewHandler(w *wrapper.Wrapper) (h *handler) {
+func NewHandler(w *wrapper.Wrapper, p *controller.Project) (h *handler) {
+	limits := p.GetLimits()
 	h = &handler{
 		wrapper: w,
+		project: p,
 		jobs:    map[int]*wrapper.Job{},
+		maxJobs: limits.MaxJobs,
+		limiter: newLimiter(limits.Rate),
+		timeout: JOB_TIMEOUT,
 	}
 	return
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Token bucket, refilled at rate tokens per second
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// Returns the time to wait before a token is available
+// when the request is not allowed
+func (l *limiter) allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	l.tokens = math.Min(l.tokens, l.rate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	wait := (1 - l.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+func newLimiter(rate int) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &limiter{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
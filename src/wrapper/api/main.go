@@ -1,13 +1,16 @@
 package main
 
 import (
+	"crypto/tls"
 	"net/http"
 	"os"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 	"github.com/francisbouvier/pipes/src/controller"
+	"github.com/francisbouvier/pipes/src/metrics"
 	"github.com/francisbouvier/pipes/src/store/etcd"
+	"github.com/francisbouvier/pipes/src/utils"
 	"github.com/francisbouvier/pipes/src/wrapper"
 	"github.com/julienschmidt/httprouter"
 )
@@ -26,8 +29,11 @@ func launch(storeAddr, projectID, addr string) error {
 		return err
 	}
 
+	// Metrics
+	metrics.Serve("0.0.0.0:" + metrics.PORT)
+
 	// Private client
-	private, err := wrapper.ConnectWamp(routerAddr, "realm1")
+	private, err := wrapper.ConnectWamp(routerAddr, project.Realm)
 	if err != nil {
 		return err
 	}
@@ -39,14 +45,24 @@ func launch(storeAddr, projectID, addr string) error {
 	}
 
 	// Handler and router
-	h := NewHandler(w)
+	h := NewHandler(w, project)
 	router := httprouter.New()
-	router.POST("/", h.httpAPI)
-	router.GET("/jobs/:id/", h.httpJob)
+	router.POST("/", h.auth(h.httpAPI))
+	router.GET("/jobs/:id/", h.auth(h.httpJob))
 
 	// Server
+	server := &http.Server{Addr: addr, Handler: router}
+	if cert, key, err := project.TLSKeyPair(); err == nil {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{pair}}
+		log.Infoln("Serving API over HTTPS on:", addr)
+		return server.ListenAndServeTLS("", "")
+	}
 	log.Infoln("Serving API on:", addr)
-	return http.ListenAndServe(addr, router)
+	return server.ListenAndServe()
 }
 
 var logLevelFlag = cli.StringFlag{
@@ -55,6 +71,12 @@ var logLevelFlag = cli.StringFlag{
 	Usage: "Log verbose output (debug, info, warn).",
 }
 
+var logFormatFlag = cli.StringFlag{
+	Name:  "log-format",
+	Value: "text",
+	Usage: "Log format (text, json).",
+}
+
 func main() {
 
 	app := cli.NewApp()
@@ -63,7 +85,7 @@ func main() {
 	app.Author = "Francis Bouvier <francis.bouvier@gmail.com>"
 	app.Version = "0.1.0"
 	app.Usage = "API for pipes, micro-services framework"
-	app.Flags = []cli.Flag{logLevelFlag}
+	app.Flags = []cli.Flag{logLevelFlag, logFormatFlag}
 
 	app.Action = func(c *cli.Context) {
 		switch c.String("log") {
@@ -74,6 +96,9 @@ func main() {
 		default:
 			log.SetLevel(log.InfoLevel)
 		}
+		if err := utils.SetLogFormat(c.String("log-format")); err != nil {
+			log.Fatalln(err)
+		}
 
 		storeAddr := c.Args()[0]
 		projectID := c.Args()[1]
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 	"github.com/francisbouvier/pipes/src/controller"
+	"github.com/francisbouvier/pipes/src/metrics"
 	"github.com/francisbouvier/pipes/src/store/etcd"
+	"github.com/francisbouvier/pipes/src/utils"
 	"github.com/francisbouvier/pipes/src/wrapper"
 )
 
+// Time for the router to acknowledge the registration
+const REGISTER_TIMEOUT = 30 * time.Second
+
 func launch(storeAddr, projectID, service string) error {
 
 	// Store, project and router
@@ -25,8 +34,11 @@ func launch(storeAddr, projectID, service string) error {
 		return err
 	}
 
+	// Metrics
+	metrics.Serve("0.0.0.0:" + metrics.PORT)
+
 	// Client
-	client, err := wrapper.ConnectWamp(routerAddr, "realm1")
+	client, err := wrapper.ConnectWamp(routerAddr, project.Realm)
 	if err != nil {
 		return err
 	}
@@ -47,29 +59,69 @@ func launch(storeAddr, projectID, service string) error {
 		return err
 	}
 
+	// Drain the calls in progress when stopped
 	uri := fmt.Sprintf("com.%s.%s", project.ID, service)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		log.Infoln("Draining calls in progress")
+		w.Drain(uri)
+		// Exiting closes the session on the router
+		os.Exit(0)
+	}()
+
+	// Containers are named after their hostname
+	hostname, _ := os.Hostname()
+
+	// A rejected registration fails the container,
+	// instead of leaving the controller waiting
 	rp := client.Register(uri, w.Procedure)
-	<-rp.Registred
+	registered := false
+	select {
+	case registered = <-rp.Registred:
+	case <-time.After(REGISTER_TIMEOUT):
+	}
+	if !registered {
+		reason := fmt.Sprintf("Failed to register %s", uri)
+		if err = project.SetFailed(service, hostname, reason); err != nil {
+			log.Infoln("Failed to mark the service as failed:", err)
+		}
+		client.End()
+		return errors.New(reason)
+	}
+	if err = project.SetReady(service, hostname); err != nil {
+		return err
+	}
 
 	client.End()
 	return nil
 }
 
+// Same as the version of pipes, the builder checks it in the sources
+var VERSION = "0.1.0"
+
 var logLevelFlag = cli.StringFlag{
 	Name:  "log, l",
 	Value: "info",
 	Usage: "Log verbose output (debug, info, warn).",
 }
 
+var logFormatFlag = cli.StringFlag{
+	Name:  "log-format",
+	Value: "text",
+	Usage: "Log format (text, json).",
+}
+
 func main() {
 
 	app := cli.NewApp()
 
 	app.Name = "pipes_client"
 	app.Author = "Francis Bouvier <francis.bouvier@gmail.com>"
-	app.Version = "0.1.0"
+	app.Version = VERSION
 	app.Usage = "Client for pipes, micro-services framework"
-	app.Flags = []cli.Flag{logLevelFlag}
+	app.Flags = []cli.Flag{logLevelFlag, logFormatFlag}
 
 	app.Action = func(c *cli.Context) {
 		switch c.String("log") {
@@ -80,6 +132,9 @@ func main() {
 		default:
 			log.SetLevel(log.InfoLevel)
 		}
+		if err := utils.SetLogFormat(c.String("log-format")); err != nil {
+			log.Fatalln(err)
+		}
 
 		storeAddr := c.Args()[0]
 		projectID := c.Args()[1]
@@ -2,6 +2,7 @@ package wrapper
 
 import (
 	"math/rand"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/francisbouvier/wampace/wamp"
@@ -25,9 +26,16 @@ type Job struct {
 	Responses []interface{}
 	Finish    chan bool
 	status    status
+	mu        sync.Mutex
 }
 
 func (j *Job) Finished(code int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.finished(code)
+}
+
+func (j *Job) finished(code int) {
 	switch code {
 	case 2:
 		j.status = status{Code: 2, Message: "Success"}
@@ -37,11 +45,32 @@ func (j *Job) Finished(code int) {
 }
 
 func (j *Job) Status() status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
 	return j.status
 }
 
+// Fail the job if it is not finished yet,
+// its results arriving later are ignored
+func (j *Job) Fail(msg string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.Code >= 2 {
+		return false
+	}
+	j.Responses = []interface{}{map[string]interface{}{"error": msg}}
+	j.finished(3)
+	return true
+}
+
 func (j *Job) result(args []interface{}, kwargs map[string]interface{}) {
 	log.Debugln("Result call with args:", args)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.Code >= 2 {
+		// Failed before
+		return
+	}
 	// Consolidize reponses
 	// Starting from here we are going outside the Wamp protocole definition
 	// with len(args) fixe to 0
@@ -63,20 +92,22 @@ func (j *Job) result(args []interface{}, kwargs map[string]interface{}) {
 				break
 			}
 		}
+		j.finished(code)
 		j.Finish <- true
-		j.Finished(code)
 	}
 }
 
-func (j *Job) call(c *wamp.Client, args []interface{}) {
+func (j *Job) call(c *wamp.Client, args []interface{}, kwargs map[string]interface{}) {
+	j.mu.Lock()
+	j.status = status{Code: 1, Message: "Started"}
+	j.mu.Unlock()
 	for _, s := range j.services {
-		go func() {
-			rc := c.Call(s.uri, args, map[string]interface{}{})
+		go func(s service) {
+			rc := c.Call(s.uri, args, kwargs)
 			<-rc.Result
 			j.result(rc.Args, rc.Kwargs)
-		}()
+		}(s)
 	}
-	j.status = status{Code: 1, Message: "Started"}
 }
 
 func NewJob(services map[string]service) (j *Job) {
@@ -0,0 +1,48 @@
+package wrapper
+
+import (
+	"os/exec"
+	"syscall"
+
+	"github.com/francisbouvier/pipes/src/metrics"
+)
+
+var (
+	JobsTotal = metrics.NewCounter(
+		"pipes_jobs_total",
+		"Jobs by status.",
+		"status",
+	)
+	JobsInFlight = metrics.NewGauge(
+		"pipes_jobs_in_flight",
+		"Jobs currently running.",
+	)
+	stageDuration = metrics.NewHistogram(
+		"pipes_stage_duration_seconds",
+		"Execution time of the service executable.",
+		metrics.DefaultBuckets,
+		"service",
+	)
+	exitCodes = metrics.NewCounter(
+		"pipes_exec_exit_total",
+		"Exit codes of the service executable.",
+		"service", "code",
+	)
+	wampConnectRetries = metrics.NewCounter(
+		"pipes_wamp_connect_retries_total",
+		"Retries to connect to the Wamp router at startup.",
+	)
+)
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	// Failed to launch the executable
+	return -1
+}
@@ -4,26 +4,43 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"os/exec"
-	// "strconv"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/francisbouvier/pipes/src/controller"
 	"github.com/francisbouvier/pipes/src/store"
+	"github.com/francisbouvier/pipes/src/trace"
 	"github.com/francisbouvier/wampace/client"
 	"github.com/francisbouvier/wampace/wamp"
 )
 
 type Wrapper struct {
-	name     string
-	project  *controller.Project
-	st       store.Store
-	services map[string]service
-	jobs     map[int]*Job
-	c        *wamp.Client
-	Cmd      string
-	Mode     string
+	name          string
+	containerName string
+	project       *controller.Project
+	st            store.Store
+	services      map[string]service
+	jobs          map[int]*Job
+	c             *wamp.Client
+	sem           chan struct{}
+	inFlight      sync.WaitGroup
+	mu            sync.Mutex
+	draining      bool
+	Cmd           string
+	Mode          string
+}
+
+func (w *Wrapper) logger() *log.Entry {
+	return log.WithFields(log.Fields{
+		"project":        w.project.ID,
+		"service":        w.name,
+		"container_name": w.containerName,
+	})
 }
 
 func (w *Wrapper) Init() error {
@@ -38,18 +55,35 @@ func (w *Wrapper) Init() error {
 		s.uri = fmt.Sprintf("com.%s.%s", w.project.ID, serviceName)
 		w.services[serviceName] = s
 	}
-	log.Debugln("Wrapper services:", w.services)
+	w.logger().Debugln("Wrapper services:", w.services)
+	if n := w.project.GetConcurrency(w.name); n > 0 {
+		w.logger().Debugln("Wrapper concurrency:", n)
+		w.sem = make(chan struct{}, n)
+	}
 	return nil
 }
 
-func (w *Wrapper) Handle(args []interface{}) (job *Job) {
+// Kwargs carry the trace context to the next services
+func (w *Wrapper) Handle(args []interface{}, kwargs map[string]interface{}) (job *Job) {
 	job = NewJob(w.services)
+	w.mu.Lock()
 	w.jobs[job.ID] = job
-	job.call(w.c, args)
-	log.Infoln("Launch job:", job.ID)
+	w.mu.Unlock()
+	job.call(w.c, args, kwargs)
+	w.logger().WithFields(log.Fields{
+		"job":      job.ID,
+		"trace_id": kwargs[trace.TRACE_ID],
+	}).Infoln("Launch job:", job.ID)
 	return job
 }
 
+// Forget a finished job
+func (w *Wrapper) Remove(job *Job) {
+	w.mu.Lock()
+	delete(w.jobs, job.ID)
+	w.mu.Unlock()
+}
+
 func argsBin(cmd string, cmdArgs []string, args []interface{}) ([]interface{}, error) {
 	resp := []interface{}{}
 	for _, elem := range args {
@@ -58,11 +92,11 @@ func argsBin(cmd string, cmdArgs []string, args []interface{}) ([]interface{}, e
 		cmdArgs = append(cmdArgs, argList...)
 	}
 	bin := exec.Command(cmd, cmdArgs...)
+	// Output waits for the command, the error carries its exit code
 	res, err := bin.Output()
 	if err != nil {
 		return resp, err
 	}
-	bin.Wait()
 	arg := string(res)
 	arg = strings.TrimSuffix(arg, "\n")
 	log.Debugln("Result:", arg)
@@ -84,7 +118,9 @@ func stdinBin(cmd string, cmdArgs []string, args []interface{}) ([]interface{},
 		log.Debugln("Error", err)
 		return resp, err
 	}
-	bin.Start()
+	if err = bin.Start(); err != nil {
+		return resp, err
+	}
 	inArg := args[0].(string)
 	log.Debugln("inArg:", inArg)
 	in.Write([]byte(inArg + "\n"))
@@ -92,9 +128,13 @@ func stdinBin(cmd string, cmdArgs []string, args []interface{}) ([]interface{},
 	res, err := ioutil.ReadAll(out)
 	if err != nil {
 		log.Debugln("Error", err)
+		bin.Wait()
+		return resp, err
+	}
+	// The error carries the exit code
+	if err = bin.Wait(); err != nil {
 		return resp, err
 	}
-	bin.Wait()
 	arg := string(res)
 	arg = strings.TrimSuffix(arg, "\n")
 	log.Debugln("Resp:", arg)
@@ -103,7 +143,19 @@ func stdinBin(cmd string, cmdArgs []string, args []interface{}) ([]interface{},
 }
 
 func (w *Wrapper) Procedure(args []interface{}, kwargs map[string]interface{}) (resp []interface{}, k map[string]interface{}) {
-	log.Infoln("Receive call with args:", args)
+	l := w.logger().WithField("trace_id", kwargs[trace.TRACE_ID])
+	l.Infoln("Receive call with args:", args)
+	if !w.start() {
+		l.Infoln("Call rejected, draining")
+		e := map[string]interface{}{"error": "Service is draining"}
+		return []interface{}{e}, map[string]interface{}{}
+	}
+	defer w.inFlight.Done()
+
+	// Wait for a free slot if concurrency is capped
+	if w.sem != nil {
+		w.sem <- struct{}{}
+	}
 
 	// Launch binary
 	resp = []interface{}{}
@@ -112,45 +164,103 @@ func (w *Wrapper) Procedure(args []interface{}, kwargs map[string]interface{}) (
 	cmd := fullCmd[0]
 	cmdArgs := fullCmd[1:]
 	var err error
-	log.Debugln("Mode:", w.Mode)
+	l.Debugln("Mode:", w.Mode)
+	JobsInFlight.Inc()
+	span := trace.StartSpan(w.name, kwargs)
 	if w.Mode == "args" {
 		resp, err = argsBin(cmd, cmdArgs, args)
 	} else if w.Mode == "stdin" {
 		resp, err = stdinBin(cmd, cmdArgs, args)
 	}
+	span.Finish(exitCode(err))
+	stageDuration.Observe(span.Duration().Seconds(), w.name)
+	exitCodes.Inc(w.name, strconv.Itoa(span.ExitCode))
+	JobsInFlight.Dec()
+	w.Record(span)
+	if w.sem != nil {
+		<-w.sem
+	}
 	if err != nil {
+		l.WithField("exit_code", span.ExitCode).Infoln("Execution failed:", err)
+		JobsTotal.Inc("error")
 		e := map[string]interface{}{"error": err.Error()}
 		resp = []interface{}{e}
 		return
 	}
+	JobsTotal.Inc("success")
 
 	// Launch next jobs
-	job := w.Handle(resp)
+	job := w.Handle(resp, span.Context())
 	if len(job.services) > 0 {
 		<-job.Finish
 		resp = job.Responses
 	}
 	job.Finished(2)
+	w.Remove(job)
 	return
 }
 
+// Count a call in progress, unless draining
+func (w *Wrapper) start() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.draining {
+		return false
+	}
+	w.inFlight.Add(1)
+	return true
+}
+
+// Stop receiving calls on the procedure and wait for the calls in progress.
+// The session is kept until then, to return their results.
+func (w *Wrapper) Drain(uri string) {
+	if err := w.c.Unregister(uri); err != nil {
+		w.logger().Infoln("Failed to unregister:", err)
+	}
+	w.mu.Lock()
+	w.draining = true
+	w.mu.Unlock()
+	w.inFlight.Wait()
+}
+
+func (w *Wrapper) Record(span *trace.Span) {
+	if err := trace.Save(w.st, w.project.ID, span); err != nil {
+		w.logger().WithField("trace_id", span.TraceID).Infoln("Failed to record span:", err)
+	}
+}
+
 func New(name string, project *controller.Project, c *wamp.Client) (w *Wrapper) {
+	// Containers are named after their hostname, their ID is not known inside
+	hostname, _ := os.Hostname()
 	w = &Wrapper{
-		name:     name,
-		project:  project,
-		st:       project.Store,
-		services: map[string]service{},
-		jobs:     map[int]*Job{},
-		c:        c,
+		name:          name,
+		containerName: hostname,
+		project:       project,
+		st:            project.Store,
+		services:      map[string]service{},
+		jobs:          map[int]*Job{},
+		c:             c,
 	}
 	return
 }
 
-func ConnectWamp(url, realm string) (c *wamp.Client, err error) {
-	c, err = client.New(url)
-	if err != nil {
-		return
+const CONNECT_RETRIES = 5
+
+func ConnectWamp(addr, realm string) (c *wamp.Client, err error) {
+	for i := 0; i < CONNECT_RETRIES; i++ {
+		if i > 0 {
+			wampConnectRetries.Inc()
+			log.Infoln("Retrying to connect to Wamp router:", err)
+			time.Sleep(time.Duration(i) * 500 * time.Millisecond)
+		}
+		c, err = client.New(addr)
+		if err != nil {
+			continue
+		}
+		if err = c.Join(realm); err == nil {
+			return
+		}
+		c.End()
 	}
-	err = c.Join(realm)
 	return
 }